@@ -0,0 +1,114 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package inferclient
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/CortexFoundation/CortexTheseus"
+	"github.com/CortexFoundation/CortexTheseus/client"
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+	torrentfs "github.com/CortexFoundation/torrentfs/types"
+)
+
+// Client reads uploaded files and runs inferences over the RPC API of a
+// node.
+type Client struct {
+	c    *rpc.Client
+	ctxc *ctxcclient.Client
+}
+
+// Dial connects a client to the given URL.
+func Dial(rawurl string) (*Client, error) {
+	return DialContext(context.Background(), rawurl)
+}
+
+func DialContext(ctx context.Context, rawurl string) (*Client, error) {
+	c, err := rpc.DialContext(ctx, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(c), nil
+}
+
+// NewClient creates a client that uses the given RPC client.
+func NewClient(c *rpc.Client) *Client {
+	return &Client{c: c, ctxc: ctxcclient.NewClient(c)}
+}
+
+func (ic *Client) Close() {
+	ic.c.Close()
+}
+
+// ModelMeta returns the meta of the model uploaded to a contract. The block
+// number can be nil, in which case the meta is taken from the latest known
+// block.
+func (ic *Client) ModelMeta(ctx context.Context, model common.Address, blockNumber *big.Int) (*torrentfs.ModelMeta, error) {
+	code, err := ic.ctxc.CodeAt(ctx, model, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeModelMeta(code)
+}
+
+// InputMeta returns the meta of the input uploaded to a contract.
+func (ic *Client) InputMeta(ctx context.Context, input common.Address, blockNumber *big.Int) (*torrentfs.InputMeta, error) {
+	code, err := ic.ctxc.CodeAt(ctx, input, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeInputMeta(code)
+}
+
+// UploadRemaining returns the bytes of the file of a contract that are still
+// to be uploaded, zero once the upload is complete.
+func (ic *Client) UploadRemaining(ctx context.Context, file common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return ic.ctxc.UploadAt(ctx, file, blockNumber)
+}
+
+// Infer runs a model on an input off chain, the same way a contract call
+// would. The node needs the synapse API enabled.
+func (ic *Client) Infer(ctx context.Context, meta *torrentfs.ModelMeta, input []byte) ([]byte, error) {
+	var output hexutil.Bytes
+	err := ic.c.CallContext(ctx, &output, "synapse_infer", meta.Hash.Hex(), hexutil.Bytes(input))
+	return output, err
+}
+
+// InferByHash runs a model on an input published in the storage off chain.
+func (ic *Client) InferByHash(ctx context.Context, model *torrentfs.ModelMeta, input *torrentfs.InputMeta) ([]byte, error) {
+	var output hexutil.Bytes
+	err := ic.c.CallContext(ctx, &output, "synapse_inferByHash", model.Hash.Hex(), input.Hash.Hex())
+	return output, err
+}
+
+// Call calls a method of a contract making an inference without a
+// transaction and decodes the output the inference wrote to the returned
+// array. shape is the output shape of the model.
+func (ic *Client) Call(ctx context.Context, contract *Contract, from common.Address, shape []uint64, method string, args ...interface{}) ([]byte, error) {
+	data, err := contract.CallData(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := ic.ctxc.CallContract(ctx, cortex.CallMsg{From: from, To: &contract.Address, Data: data}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return contract.Output(method, ret, shape)
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package inferclient
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/CortexFoundation/CortexTheseus/accounts/abi"
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+)
+
+var errOutputSize = errors.New("inference output larger than its array")
+
+// Contract is a deployed contract making inferences, with its ABI.
+type Contract struct {
+	Address common.Address
+	ABI     abi.ABI
+}
+
+// NewContract binds the contract at addr to its json ABI.
+func NewContract(addr common.Address, abiJSON string) (*Contract, error) {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return nil, err
+	}
+	return &Contract{Address: addr, ABI: parsed}, nil
+}
+
+// CallData returns the input of a call to a method of the contract.
+func (c *Contract) CallData(method string, args ...interface{}) ([]byte, error) {
+	return c.ABI.Pack(method, args...)
+}
+
+// NewInference returns a transaction calling a method of the contract, for
+// inferences recorded on chain.
+func (c *Contract) NewInference(nonce uint64, gasLimit uint64, gasPrice *big.Int, method string, args ...interface{}) (*types.Transaction, error) {
+	data, err := c.CallData(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	return types.NewTransaction(nonce, c.Address, new(big.Int), gasLimit, gasPrice, data), nil
+}
+
+// Output decodes the result of a method returning the uint256 array an
+// inference was written to. The inference writes its output bytes packed
+// from the start of the array, shape is the output shape of the model.
+func (c *Contract) Output(method string, ret []byte, shape []uint64) ([]byte, error) {
+	var words []*big.Int
+	if err := c.ABI.Unpack(&words, method, ret); err != nil {
+		return nil, err
+	}
+	return DecodeOutput(words, OutputSize(shape))
+}
+
+// OutputSize returns the number of bytes of an output of the given shape.
+func OutputSize(shape []uint64) int {
+	size := 1
+	for _, dim := range shape {
+		size *= int(dim)
+	}
+	return size
+}
+
+// DecodeOutput unpacks the first size bytes an inference wrote to a uint256
+// array.
+func DecodeOutput(words []*big.Int, size int) ([]byte, error) {
+	if size > len(words)*32 {
+		return nil, fmt.Errorf("%w: %d bytes, %d words", errOutputSize, size, len(words))
+	}
+	out := make([]byte, 0, len(words)*32)
+	for _, w := range words {
+		out = append(out, common.LeftPadBytes(w.Bytes(), 32)...)
+	}
+	return out[:size], nil
+}
+
+// EncodeOutput packs output bytes into a uint256 array the way an inference
+// writes them, the reverse of DecodeOutput.
+func EncodeOutput(output []byte) []*big.Int {
+	words := make([]*big.Int, (len(output)+31)/32)
+	for i := range words {
+		word := make([]byte, 32)
+		copy(word, output[i*32:])
+		words[i] = new(big.Int).SetBytes(word)
+	}
+	return words
+}
+
+// Int8s returns the output bytes as the signed values of the model.
+func Int8s(output []byte) []int8 {
+	values := make([]int8, len(output))
+	for i, b := range output {
+		values[i] = int8(b)
+	}
+	return values
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package inferclient
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	torrentfs "github.com/CortexFoundation/torrentfs/types"
+)
+
+func TestModelMetaRoundTrip(t *testing.T) {
+	meta := &torrentfs.ModelMeta{
+		Comment:       "mnist",
+		Hash:          common.HexToAddress("0x5c4d1f84063be8e25e83da6452b1821926548b3c"),
+		RawSize:       10 * 1024 * 1024,
+		InputShape:    []uint64{1, 28, 28},
+		OutputShape:   []uint64{10},
+		Gas:           100,
+		AuthorAddress: common.HexToAddress("0x1"),
+	}
+	for _, version := range []uint8{torrentfs.MetaVersionLegacy, torrentfs.MetaVersion1} {
+		data, err := EncodeModelMeta(meta, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, torrentfs.ModelMetaCode) {
+			t.Fatalf("version %d: model meta prefix mismatch: have %x", version, data[:2])
+		}
+		if versioned := data[2] < 0xc0; versioned != (version != torrentfs.MetaVersionLegacy) {
+			t.Errorf("version %d: version byte mismatch: have %x", version, data[2])
+		}
+		decoded, err := DecodeModelMeta(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, meta) {
+			t.Errorf("version %d: decoded model meta mismatch: have %+v, want %+v", version, decoded, meta)
+		}
+		if _, err := DecodeInputMeta(data); err == nil {
+			t.Errorf("version %d: model meta decoded as input meta", version)
+		}
+	}
+}
+
+func TestInputMetaRoundTrip(t *testing.T) {
+	meta := &torrentfs.InputMeta{
+		Comment: "digit",
+		Hash:    common.HexToAddress("0x2"),
+		RawSize: 784,
+		Shape:   []uint64{1, 28, 28},
+	}
+	for _, version := range []uint8{torrentfs.MetaVersionLegacy, torrentfs.MetaVersion1} {
+		data, err := EncodeInputMeta(meta, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeInputMeta(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, meta) {
+			t.Errorf("version %d: decoded input meta mismatch: have %+v, want %+v", version, decoded, meta)
+		}
+	}
+}
+
+func TestMetaVersionGate(t *testing.T) {
+	meta := &torrentfs.ModelMeta{Hash: common.HexToAddress("0x2"), RawSize: 1024, AuthorAddress: common.HexToAddress("0x1")}
+	legacy, _ := EncodeModelMeta(meta, torrentfs.MetaVersionLegacy)
+	versioned, _ := EncodeModelMeta(meta, torrentfs.MetaVersion1)
+
+	// Before the fork only legacy metas decode.
+	if _, _, err := torrentfs.ParseModelMeta(legacy, torrentfs.MetaVersionLegacy); err != nil {
+		t.Errorf("legacy meta refused before the fork: %v", err)
+	}
+	if _, _, err := torrentfs.ParseModelMeta(versioned, torrentfs.MetaVersionLegacy); !errors.Is(err, torrentfs.ErrUnknownMetaVersion) {
+		t.Errorf("versioned meta error mismatch before the fork: have %v, want %v", err, torrentfs.ErrUnknownMetaVersion)
+	}
+	if _, version, err := torrentfs.ParseModelMeta(versioned, torrentfs.MaxMetaVersion); err != nil || version != torrentfs.MetaVersion1 {
+		t.Errorf("versioned meta after the fork: have version %d, error %v", version, err)
+	}
+	// A zero byte is no version, it fails like before versions existed.
+	zero := append(append([]byte{}, torrentfs.ModelMetaCode...), append([]byte{0}, legacy[2:]...)...)
+	if _, _, err := torrentfs.ParseModelMeta(zero, torrentfs.MaxMetaVersion); err == nil {
+		t.Error("meta with a zero version byte decoded")
+	}
+	// Newer versions are neither written nor read.
+	if _, err := EncodeModelMeta(meta, torrentfs.MaxMetaVersion+1); !errors.Is(err, torrentfs.ErrUnknownMetaVersion) {
+		t.Errorf("unknown version encode error mismatch: have %v, want %v", err, torrentfs.ErrUnknownMetaVersion)
+	}
+	future := append(append([]byte{}, torrentfs.ModelMetaCode...), append([]byte{torrentfs.MaxMetaVersion + 1}, legacy[2:]...)...)
+	if _, err := DecodeModelMeta(future); !errors.Is(err, torrentfs.ErrUnknownMetaVersion) {
+		t.Errorf("unknown version decode error mismatch: have %v, want %v", err, torrentfs.ErrUnknownMetaVersion)
+	}
+}
+
+func TestEncodeMetaChecks(t *testing.T) {
+	valid := func() *torrentfs.ModelMeta {
+		return &torrentfs.ModelMeta{Hash: common.HexToAddress("0x2"), RawSize: 1024, AuthorAddress: common.HexToAddress("0x1")}
+	}
+	tests := []struct {
+		mutate func(*torrentfs.ModelMeta)
+		want   error
+	}{
+		{func(m *torrentfs.ModelMeta) { m.Hash = common.Address{} }, ErrMetaHash},
+		{func(m *torrentfs.ModelMeta) { m.RawSize = params.MODEL_MAX_UPLOAD_BYTES + 1 }, ErrMetaSize},
+		{func(m *torrentfs.ModelMeta) { m.AuthorAddress = common.Address{} }, ErrMetaAuthor},
+		{func(m *torrentfs.ModelMeta) { m.OutputShape = []uint64{10, 0} }, ErrMetaShape},
+		{func(m *torrentfs.ModelMeta) { m.BlockNum = *big.NewInt(1) }, ErrMetaCreated},
+	}
+	for i, tt := range tests {
+		meta := valid()
+		tt.mutate(meta)
+		if _, err := EncodeModelMeta(meta, torrentfs.MetaVersionLegacy); !errors.Is(err, tt.want) {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.want)
+		}
+	}
+	if _, err := EncodeInputMeta(&torrentfs.InputMeta{Hash: common.HexToAddress("0x2")}, torrentfs.MetaVersionLegacy); !errors.Is(err, ErrMetaSize) {
+		t.Errorf("empty input error mismatch: have %v, want %v", err, ErrMetaSize)
+	}
+}
+
+func TestOutputRoundTrip(t *testing.T) {
+	output := make([]byte, 70)
+	for i := range output {
+		output[i] = byte(i * 7)
+	}
+	words := EncodeOutput(output)
+	if len(words) != 3 {
+		t.Fatalf("word count mismatch: have %d, want 3", len(words))
+	}
+	decoded, err := DecodeOutput(words, OutputSize([]uint64{7, 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, output) {
+		t.Errorf("decoded output mismatch: have %x, want %x", decoded, output)
+	}
+	if _, err := DecodeOutput(words, 97); !errors.Is(err, errOutputSize) {
+		t.Errorf("oversized output error mismatch: have %v, want %v", err, errOutputSize)
+	}
+	if have := Int8s([]byte{0xff, 0x7f}); !reflect.DeepEqual(have, []int8{-1, 127}) {
+		t.Errorf("int8 values mismatch: have %v", have)
+	}
+}
+
+func TestUploadSteps(t *testing.T) {
+	tests := []struct{ size, want uint64 }{
+		{0, 0},
+		{1, 1},
+		{params.PER_UPLOAD_BYTES, 1},
+		{params.PER_UPLOAD_BYTES + 1, 2},
+	}
+	for _, tt := range tests {
+		if have := UploadSteps(tt.size + params.DEFAULT_UPLOAD_BYTES); have != tt.want {
+			t.Errorf("steps of %d bytes mismatch: have %d, want %d", tt.size, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package inferclient wraps the interactions with the Cortex AI contracts:
+// uploading model and input files, calling contracts which make inferences
+// and decoding their results.
+package inferclient
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	torrentfs "github.com/CortexFoundation/torrentfs/types"
+)
+
+var (
+	ErrMetaHash    = errors.New("meta without info hash")
+	ErrMetaSize    = errors.New("meta raw size out of range")
+	ErrMetaAuthor  = errors.New("model meta without author")
+	ErrMetaShape   = errors.New("meta shape with empty dimension")
+	ErrMetaCreated = errors.New("meta of a file uploaded already")
+)
+
+// EncodeModelMeta returns the contract creation data uploading a model, its
+// meta encoded in version. It refuses metas the chain would reject, chains
+// only accept versions other than torrentfs.MetaVersionLegacy from their
+// MetaVersion fork on.
+func EncodeModelMeta(meta *torrentfs.ModelMeta, version uint8) ([]byte, error) {
+	if meta.Hash == (common.Address{}) {
+		return nil, ErrMetaHash
+	}
+	if meta.RawSize <= params.MODEL_MIN_UPLOAD_BYTES || meta.RawSize > params.MODEL_MAX_UPLOAD_BYTES {
+		return nil, fmt.Errorf("%w: %d not in (%d, %d]", ErrMetaSize, meta.RawSize, params.MODEL_MIN_UPLOAD_BYTES, params.MODEL_MAX_UPLOAD_BYTES)
+	}
+	if meta.AuthorAddress == (common.Address{}) {
+		return nil, ErrMetaAuthor
+	}
+	if err := checkShape(meta.InputShape); err != nil {
+		return nil, err
+	}
+	if err := checkShape(meta.OutputShape); err != nil {
+		return nil, err
+	}
+	if meta.BlockNum.Sign() != 0 {
+		return nil, ErrMetaCreated
+	}
+	return torrentfs.EncodeMeta(torrentfs.ModelMetaCode, version, meta)
+}
+
+// EncodeInputMeta returns the contract creation data uploading an input, its
+// meta encoded in version.
+func EncodeInputMeta(meta *torrentfs.InputMeta, version uint8) ([]byte, error) {
+	if meta.Hash == (common.Address{}) {
+		return nil, ErrMetaHash
+	}
+	if meta.RawSize == 0 {
+		return nil, fmt.Errorf("%w: empty input", ErrMetaSize)
+	}
+	if err := checkShape(meta.Shape); err != nil {
+		return nil, err
+	}
+	if meta.BlockNum.Sign() != 0 {
+		return nil, ErrMetaCreated
+	}
+	return torrentfs.EncodeMeta(torrentfs.InputMetaCode, version, meta)
+}
+
+func checkShape(shape []uint64) error {
+	for _, dim := range shape {
+		if dim == 0 {
+			return ErrMetaShape
+		}
+	}
+	return nil
+}
+
+// DecodeModelMeta decodes the code of an uploaded model, whatever the version
+// of its meta.
+func DecodeModelMeta(code []byte) (*torrentfs.ModelMeta, error) {
+	meta, _, err := torrentfs.ParseModelMeta(code, torrentfs.MaxMetaVersion)
+	return meta, err
+}
+
+// DecodeInputMeta decodes the code of an uploaded input, whatever the
+// version of its meta.
+func DecodeInputMeta(code []byte) (*torrentfs.InputMeta, error) {
+	meta, _, err := torrentfs.ParseInputMeta(code, torrentfs.MaxMetaVersion)
+	return meta, err
+}
+
+// NewModelUpload returns the transaction creating the contract of a model.
+// The file itself is seeded to the storage, the chain only learns about its
+// progress through the transactions of NewUploadProgress.
+func NewModelUpload(nonce uint64, meta *torrentfs.ModelMeta, version uint8, gasLimit uint64, gasPrice *big.Int) (*types.Transaction, error) {
+	data, err := EncodeModelMeta(meta, version)
+	if err != nil {
+		return nil, err
+	}
+	return types.NewContractCreation(nonce, new(big.Int), gasLimit, gasPrice, data), nil
+}
+
+// NewInputUpload returns the transaction creating the contract of an input.
+func NewInputUpload(nonce uint64, meta *torrentfs.InputMeta, version uint8, gasLimit uint64, gasPrice *big.Int) (*types.Transaction, error) {
+	data, err := EncodeInputMeta(meta, version)
+	if err != nil {
+		return nil, err
+	}
+	return types.NewContractCreation(nonce, new(big.Int), gasLimit, gasPrice, data), nil
+}
+
+// NewUploadProgress returns a transaction advancing the upload of the file
+// of a contract by params.PER_UPLOAD_BYTES.
+func NewUploadProgress(nonce uint64, file common.Address, gasPrice *big.Int) *types.Transaction {
+	return types.NewTransaction(nonce, file, new(big.Int), params.UploadGas, gasPrice, nil)
+}
+
+// UploadSteps returns the number of progress transactions uploading a file
+// of rawSize bytes.
+func UploadSteps(rawSize uint64) uint64 {
+	if rawSize <= params.DEFAULT_UPLOAD_BYTES {
+		return 0
+	}
+	left := rawSize - params.DEFAULT_UPLOAD_BYTES
+	return (left + params.PER_UPLOAD_BYTES - 1) / params.PER_UPLOAD_BYTES
+}
@@ -38,6 +38,7 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/event"
 	"github.com/CortexFoundation/CortexTheseus/log"
 	"github.com/CortexFoundation/CortexTheseus/trie"
+	"github.com/CortexFoundation/torrentfs"
 	//"github.com/ucwong/goleveldb/leveldb/util"
 	"gopkg.in/urfave/cli.v1"
 )
@@ -167,6 +168,20 @@ The first argument must be the directory containing the blockchain to download f
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
 Remove blockchain and state databases`,
+	}
+	reindexfsCommand = cli.Command{
+		Action:    utils.MigrateFlags(reindexFs),
+		Name:      "reindexfs",
+		Usage:     "Rebuild the indexes of the file storage",
+		ArgsUsage: " ",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.StorageDirFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+Rebuild the info hash, contract address and block indexes of the file storage
+from the stored files and blocks. The node must not be running.`,
 	}
 	dumpCommand = cli.Command{
 		Action:    utils.MigrateFlags(dump),
@@ -564,3 +579,20 @@ func hashish(x string) bool {
 	_, err := strconv.Atoi(x)
 	return err != nil
 }
+
+func reindexFs(ctx *cli.Context) error {
+	_, config := makeConfigNode(ctx)
+
+	fs, err := torrentfs.NewChainDB(&config.TorrentFs)
+	if err != nil {
+		utils.Fatalf("Failed to open file storage: %v", err)
+	}
+	defer fs.Close()
+
+	start := time.Now()
+	if err := fs.RebuildIndexes(); err != nil {
+		utils.Fatalf("Failed to rebuild file storage indexes: %v", err)
+	}
+	log.Info("File storage indexes rebuilt", "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
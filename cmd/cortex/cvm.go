@@ -30,7 +30,7 @@ import (
 	"syscall"
 	"time"
 
-	"errors"
+	"fmt"
 	"github.com/CortexFoundation/CortexTheseus/cmd/utils"
 	"github.com/CortexFoundation/CortexTheseus/inference/synapse"
 	"github.com/CortexFoundation/CortexTheseus/log"
@@ -196,7 +196,7 @@ func cvmServer(ctx *cli.Context) error {
 	log.Debug("Cvm Server", "fs", fsCfg, "storage", ctx.GlobalString(utils.StorageDirFlag.Name), "ipc path", fsCfg.IpcPath)
 	storagefs, fs_err := torrentfs.New(&fsCfg, "", true, false)
 	if fs_err != nil {
-		return errors.New("fs start failed")
+		return fmt.Errorf("fs start failed: %v", fs_err)
 	}
 
 	err = storagefs.Start(&p2p.Server{})
@@ -273,6 +273,7 @@ func cvmServer(ctx *cli.Context) error {
 			log.Info("CVM http server closed")
 		}
 		inferServer.Close()
+		storagefs.Stop()
 		//		}
 	}(port, inferServer)
 
@@ -22,12 +22,12 @@ func AvailableHandler(w http.ResponseWriter, inferWork *inference.AvailableWork)
 	log.Debug("Available", "Model Hash", inferWork.InfoHash, "rawSize", inferWork.RawSize)
 	if inferWork.InfoHash == "" {
 		log.Warn("info hash is empty")
-		RespErrorText(w, synapse.KERNEL_RUNTIME_ERROR)
+		RespInferError(w, synapse.KERNEL_RUNTIME_ERROR)
 		return
 	}
 
 	if err := synapse.Engine().Available(inferWork.InfoHash, inferWork.RawSize); err != nil {
-		RespErrorText(w, err)
+		RespInferError(w, err)
 	} else {
 		ret_arr := Uint64ToBytes(1)
 		log.Debug("File avaiable", "hash", inferWork.InfoHash)
@@ -39,14 +39,14 @@ func gasHandler(w http.ResponseWriter, inferWork *inference.GasWork) {
 	log.Debug("Gas Task", "Model Hash", inferWork.Model)
 	if inferWork.Model == "" {
 		log.Warn("model info hash is empty")
-		RespErrorText(w, synapse.KERNEL_RUNTIME_ERROR)
+		RespInferError(w, synapse.KERNEL_RUNTIME_ERROR)
 		return
 	}
 
 	ret, err := synapse.Engine().GetGasByInfoHash(inferWork.Model)
 	if err != nil {
 		log.Warn("Gas calculate Failed", "error", err)
-		RespErrorText(w, err)
+		RespInferError(w, err)
 		return
 	}
 
@@ -58,12 +58,12 @@ func gasHandler(w http.ResponseWriter, inferWork *inference.GasWork) {
 func infoHashHandler(w http.ResponseWriter, inferWork *inference.IHWork) {
 	if inferWork.Model == "" {
 		log.Warn("model info hash is empty")
-		RespErrorText(w, synapse.KERNEL_RUNTIME_ERROR)
+		RespInferError(w, synapse.KERNEL_RUNTIME_ERROR)
 		return
 	}
 	if inferWork.Input == "" {
 		log.Warn("input info hash is empty")
-		RespErrorText(w, synapse.KERNEL_RUNTIME_ERROR)
+		RespInferError(w, synapse.KERNEL_RUNTIME_ERROR)
 		return
 	}
 
@@ -71,7 +71,7 @@ func infoHashHandler(w http.ResponseWriter, inferWork *inference.IHWork) {
 	label, err := synapse.Engine().InferByInfoHash(inferWork.Model, inferWork.Input)
 
 	if err != nil {
-		RespErrorText(w, err)
+		RespInferError(w, err)
 		return
 	}
 	RespInfoText(w, label)
@@ -80,7 +80,7 @@ func infoHashHandler(w http.ResponseWriter, inferWork *inference.IHWork) {
 func inputContentHandler(w http.ResponseWriter, inferWork *inference.ICWork) {
 	if inferWork.Model == "" {
 		log.Warn("model info hash is empty")
-		RespErrorText(w, synapse.KERNEL_RUNTIME_ERROR)
+		RespInferError(w, synapse.KERNEL_RUNTIME_ERROR)
 		return
 	}
 
@@ -104,7 +104,7 @@ func inputContentHandler(w http.ResponseWriter, inferWork *inference.ICWork) {
 	label, err := synapse.Engine().InferByInputContent(model, input)
 	if err != nil {
 		log.Warn("Infer Failed", "error", err)
-		RespErrorText(w, err)
+		RespInferError(w, err)
 		return
 	}
 
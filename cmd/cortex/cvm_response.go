@@ -9,6 +9,7 @@ import (
 
 	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
 	"github.com/CortexFoundation/CortexTheseus/inference"
+	"github.com/CortexFoundation/CortexTheseus/inference/synapse"
 	"github.com/CortexFoundation/CortexTheseus/log"
 )
 
@@ -62,6 +63,23 @@ func RespErrorText(w http.ResponseWriter, ctx ...interface{}) {
 	w.Write(data)
 }
 
+// RespInferError answers with a failed inference, carrying the error code so
+// the node classifies the failure exactly as a local inference would.
+func RespInferError(w http.ResponseWriter, err error) {
+	var res = &inference.InferResult{
+		Info: inference.RES_ERROR,
+		Data: hexutil.Bytes(err.Error()),
+		Code: synapse.EncodeError(err),
+	}
+
+	data, jsErr := json.Marshal(res)
+	if jsErr != nil {
+		log.Error("Json marshal invalid", "err", jsErr, "res", res)
+		return
+	}
+	w.Write(data)
+}
+
 func RespInfoText(w http.ResponseWriter, result []byte) {
 	var res = &inference.InferResult{
 		Info: inference.RES_OK,
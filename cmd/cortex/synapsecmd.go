@@ -0,0 +1,163 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of CortexFoundation.
+//
+// CortexFoundation is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexFoundation is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexFoundation. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/CortexFoundation/CortexTheseus/cmd/utils"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/inference/synapse"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+var (
+	synapseCommand = cli.Command{
+		Name:     "synapse",
+		Usage:    "Debug the inference engine",
+		Category: "INFERENCE COMMANDS",
+		Subcommands: []cli.Command{
+			{
+				Name:      "replay",
+				Usage:     "Rerun dumped inferences and compare their outputs",
+				ArgsUsage: "<dumpfile|dumpdir>",
+				Action:    utils.MigrateFlags(synapseReplay),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.StorageDirFlag,
+					utils.InferDeviceTypeFlag,
+					utils.InferDeviceIdFlag,
+					utils.InferDeterministicFlag,
+				},
+				Description: `
+    cortex synapse replay <dumpfile|dumpdir>
+
+Reruns the inferences dumped by a node started with --infer.dump, reading
+models and inputs from the storage directory of a stopped node, and reports
+every inference whose output differs from the dumped one.`,
+			},
+			{
+				Name:      "check",
+				Usage:     "Validate the files of a model before uploading it",
+				ArgsUsage: "<modeldir>",
+				Action:    utils.MigrateFlags(synapseCheck),
+				Description: `
+    cortex synapse check <modeldir>
+
+Parses the graph and parameters of a model laid out the way it is published,
+as data/symbol and data/params or as data/model.onnx, without executing it.
+Reports the operators the runtime lacks, the parameter count, an estimate of
+the memory needed to run the model and the expected input shape.`,
+			},
+			{
+				Name:      "worker",
+				Usage:     "Serve sandboxed inferences of a node",
+				ArgsUsage: "<socket>",
+				Action:    synapseWorker,
+				Hidden:    true,
+				Description: `
+The worker process started by a node running with --infer.sandbox. It
+connects to the local socket of its parent and runs the inferences it
+receives until the node closes the connection.`,
+			},
+		},
+	}
+)
+
+func synapseReplay(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires a dump file or directory as its argument")
+	}
+	dumps, err := synapse.ReadDumps(ctx.Args().First())
+	if err != nil {
+		utils.Fatalf("Failed to read inference dumps: %v", err)
+	}
+
+	deviceType := ctx.GlobalString(utils.InferDeviceTypeFlag.Name)
+	if deviceType == "gpu" {
+		deviceType = "cuda"
+	}
+	engine := synapse.New(&synapse.Config{
+		IsNotCache:     true,
+		DeviceType:     deviceType,
+		DeviceId:       ctx.GlobalInt(utils.InferDeviceIdFlag.Name),
+		Deterministic:  ctx.GlobalBool(utils.InferDeterministicFlag.Name),
+		MaxMemoryUsage: synapse.DefaultConfig.MaxMemoryUsage,
+	})
+	if engine == nil {
+		utils.Fatalf("Failed to load the inference runtime for device %q", deviceType)
+	}
+	defer engine.Close()
+	engine.RegisterSource("torrent", synapse.NewDirSource(utils.MakeStorageDir(ctx)))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BLOCK\tTX\tMODEL\tRESULT\tDETAIL")
+	mismatches := 0
+	for _, d := range dumps {
+		output, err := engine.Replay(d)
+		if d.Matches(output, err) {
+			fmt.Fprintf(w, "%d\t%s\t%s\tmatch\t\n", d.Block, d.Tx.TerminalString(), d.Model)
+			continue
+		}
+		mismatches++
+		got := hexutil.Encode(output)
+		if err != nil {
+			got = err.Error()
+		}
+		want := d.Output.String()
+		if d.Error != "" {
+			want = d.Error
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\tMISMATCH\tgot %s, want %s\n", d.Block, d.Tx.TerminalString(), d.Model, got, want)
+	}
+	w.Flush()
+
+	if mismatches > 0 {
+		utils.Fatalf("%d of %d inferences mismatched", mismatches, len(dumps))
+	}
+	fmt.Printf("All %d inferences matched\n", len(dumps))
+	return nil
+}
+
+func synapseCheck(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires a model directory as its argument")
+	}
+	check, err := synapse.CheckModelDir(ctx.Args().First())
+	if err != nil {
+		utils.Fatalf("Failed to read the model: %v", err)
+	}
+	out, _ := json.MarshalIndent(check, "", "  ")
+	fmt.Println(string(out))
+	if !check.Ok {
+		utils.Fatalf("Model check failed")
+	}
+	return nil
+}
+
+func synapseWorker(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires the socket of the node as its argument")
+	}
+	if err := synapse.ServeSandboxSocket(ctx.Args().First()); err != nil {
+		utils.Fatalf("Sandbox worker failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,500 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of CortexFoundation.
+//
+// CortexFoundation is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexFoundation is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexFoundation. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/CortexFoundation/CortexTheseus/cmd/utils"
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+	"github.com/CortexFoundation/torrentfs"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+var (
+	torrentfsEndpointFlag = cli.StringFlag{
+		Name:  "endpoint",
+		Usage: "RPC endpoint of the node (default: the IPC endpoint in the data directory)",
+	}
+	torrentfsJSONFlag = cli.BoolFlag{
+		Name:  "json",
+		Usage: "Print the output as JSON",
+	}
+	torrentfsFormatFlag = cli.StringFlag{
+		Name:  "format",
+		Usage: "Output format of the export (csv, json or archive)",
+		Value: "csv",
+	}
+	torrentfsSignKeyFlag = cli.StringFlag{
+		Name:  "signkey",
+		Usage: "File holding the hex private key an archive export is signed with",
+	}
+	torrentfsOutputFlag = cli.StringFlag{
+		Name:  "output",
+		Usage: "File the export is written to (default: standard output)",
+	}
+	torrentfsKindFlag = cli.StringFlag{
+		Name:  "kind",
+		Usage: "Kind of the published file (model or input)",
+		Value: "model",
+	}
+	torrentfsCommentFlag = cli.StringFlag{
+		Name:  "comment",
+		Usage: "Comment stored in the meta of the published file",
+	}
+	torrentfsAuthorFlag = cli.StringFlag{
+		Name:  "author",
+		Usage: "Author address of a published model",
+	}
+	torrentfsInputShapeFlag = cli.StringFlag{
+		Name:  "inputshape",
+		Usage: "Comma separated input shape of a published model",
+	}
+	torrentfsOutputShapeFlag = cli.StringFlag{
+		Name:  "outputshape",
+		Usage: "Comma separated output shape of a published model",
+	}
+	torrentfsShapeFlag = cli.StringFlag{
+		Name:  "shape",
+		Usage: "Comma separated shape of a published input",
+	}
+	torrentfsMetaVersionFlag = cli.UintFlag{
+		Name:  "metaversion",
+		Usage: "Encoding version of the meta, above 0 only once the chain passed its meta version fork",
+	}
+	torrentfsFlags = []cli.Flag{
+		utils.DataDirFlag,
+		torrentfsEndpointFlag,
+		torrentfsJSONFlag,
+	}
+
+	torrentfsCommand = cli.Command{
+		Name:     "torrentfs",
+		Usage:    "Inspect the file storage of a running node",
+		Category: "STORAGE COMMANDS",
+		Description: `
+Query the torrents of a running node through its torrentfs RPC API.`,
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List all torrents",
+				Action: utils.MigrateFlags(torrentfsList),
+				Flags:  torrentfsFlags,
+				Description: `
+    cortex torrentfs list
+
+Prints the state, size and progress of every torrent.`,
+			},
+			{
+				Name:      "info",
+				Usage:     "Show the state and files of a torrent",
+				ArgsUsage: "<infohash>",
+				Action:    utils.MigrateFlags(torrentfsInfo),
+				Flags:     torrentfsFlags,
+			},
+			{
+				Name:      "verify",
+				Usage:     "Hash all pieces of a downloaded torrent",
+				ArgsUsage: "<infohash>",
+				Action:    utils.MigrateFlags(torrentfsVerify),
+				Flags:     torrentfsFlags,
+			},
+			{
+				Name:      "remove",
+				Usage:     "Delete a downloaded torrent and forget its file",
+				ArgsUsage: "<infohash>",
+				Action:    utils.MigrateFlags(torrentfsRemove),
+				Flags:     torrentfsFlags,
+				Description: `
+    cortex torrentfs remove <infohash>
+
+Deletes the data of a downloaded torrent. The file is dropped from the
+file storage, so later uploads to its contracts are ignored.`,
+			},
+			{
+				Name:   "export",
+				Usage:  "Dump the metadata of all discovered files",
+				Action: utils.MigrateFlags(torrentfsExport),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.StorageDirFlag,
+					torrentfsFormatFlag,
+					torrentfsOutputFlag,
+					torrentfsSignKeyFlag,
+				},
+				Description: `
+    cortex torrentfs export [--format csv|json] [--output <file>]
+    cortex torrentfs export --format archive --signkey <keyfile> [--output <file>]
+
+Reads the file storage of a stopped node and writes one record per upload,
+in block order: the block, the upload transaction, the info hash, the raw
+and unpaid sizes and the contract addresses of the file. CSV output starts
+with a header line, JSON output has one object per line. Records are
+streamed, so registries of any size can be exported.
+
+The archive format also holds every block recorded by the storage and is
+signed with the given key. Nodes started with --storage.archive and
+--storage.archive_signer fill an empty storage from it, then follow the
+chain from its last block.`,
+			},
+			{
+				Name:      "torrent",
+				Usage:     "Write the .torrent file of a torrent",
+				ArgsUsage: "<infohash>",
+				Action:    utils.MigrateFlags(torrentfsTorrent),
+				Flags:     append([]cli.Flag{torrentfsOutputFlag}, torrentfsFlags...),
+				Description: `
+    cortex torrentfs torrent [--output <file>] <infohash>
+
+Writes a standard .torrent file of a torrent whose metadata the node
+resolved, to <infohash>.torrent unless another file is given, so the
+swarm can be mirrored with other clients or its metadata archived.`,
+			},
+			{
+				Name:      "publish",
+				Usage:     "Seed a local model or input and print its upload payload",
+				ArgsUsage: "<modeldir|inputfile>",
+				Action:    utils.MigrateFlags(torrentfsPublish),
+				Flags: append([]cli.Flag{
+					torrentfsKindFlag,
+					torrentfsCommentFlag,
+					torrentfsAuthorFlag,
+					torrentfsInputShapeFlag,
+					torrentfsOutputShapeFlag,
+					torrentfsShapeFlag,
+					torrentfsMetaVersionFlag,
+				}, torrentfsFlags...),
+				Description: `
+    cortex torrentfs publish [--kind model|input] <modeldir|inputfile>
+
+Builds the torrent of a model directory, holding its symbol and params
+files, or of an input file, and has the node seed it. The path is read by
+the node, so it must lie below the directory the node was started with
+as --storage.publish_dir. Prints the info hash and
+the payload to send as the data of a contract creation transaction, then
+the number of upload transactions to send to the new contract once it is
+old enough to be paid for.`,
+			},
+		},
+	}
+)
+
+// dialTorrentfs connects to the node the torrentfs commands operate on.
+func dialTorrentfs(ctx *cli.Context) *rpc.Client {
+	endpoint := ctx.GlobalString(torrentfsEndpointFlag.Name)
+	if endpoint == "" {
+		endpoint = filepath.Join(utils.MakeDataDir(ctx), clientIdentifier+".ipc")
+	}
+	client, err := dialRPC(endpoint)
+	if err != nil {
+		utils.Fatalf("Unable to attach to cortex: %v", err)
+	}
+	return client
+}
+
+func torrentfsArg(ctx *cli.Context) string {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires an info hash as its argument")
+	}
+	return ctx.Args().First()
+}
+
+func printJSON(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		utils.Fatalf("Failed to encode output: %v", err)
+	}
+	fmt.Println(string(out))
+}
+
+func torrentProgress(t torrentfs.TorrentInfo) string {
+	if t.Size == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(t.Completed)*100/float64(t.Size))
+}
+
+func torrentfsList(ctx *cli.Context) error {
+	client := dialTorrentfs(ctx)
+	defer client.Close()
+
+	var torrents []torrentfs.TorrentInfo
+	if err := client.Call(&torrents, "torrentfs_list"); err != nil {
+		utils.Fatalf("Failed to list torrents: %v", err)
+	}
+	if ctx.GlobalBool(torrentfsJSONFlag.Name) {
+		printJSON(torrents)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "INFOHASH\tSTATUS\tSIZE\tPROGRESS\tPEERS\tNAME")
+	for _, t := range torrents {
+		fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%d\t%s\n", t.InfoHash, t.Status, common.StorageSize(t.Size), torrentProgress(t), t.Peers, t.Name)
+	}
+	return w.Flush()
+}
+
+func torrentfsInfo(ctx *cli.Context) error {
+	ih := torrentfsArg(ctx)
+	client := dialTorrentfs(ctx)
+	defer client.Close()
+
+	var t torrentfs.TorrentInfo
+	if err := client.Call(&t, "torrentfs_info", ih); err != nil {
+		utils.Fatalf("Failed to get torrent: %v", err)
+	}
+	if ctx.GlobalBool(torrentfsJSONFlag.Name) {
+		printJSON(t)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Info hash:\t%s\n", t.InfoHash)
+	fmt.Fprintf(w, "Name:\t%s\n", t.Name)
+	fmt.Fprintf(w, "Status:\t%s\n", t.Status)
+	fmt.Fprintf(w, "Size:\t%v\n", common.StorageSize(t.Size))
+	fmt.Fprintf(w, "Completed:\t%v (%s)\n", common.StorageSize(t.Completed), torrentProgress(t))
+	fmt.Fprintf(w, "Requested:\t%v\n", common.StorageSize(t.Requested))
+	fmt.Fprintf(w, "Uploaded:\t%v\n", common.StorageSize(t.Uploaded))
+	fmt.Fprintf(w, "Peers:\t%d / %d\n", t.Peers, t.MaxPeers)
+	fmt.Fprintf(w, "Pieces:\t%d\n", t.Pieces)
+	for i, f := range t.Files {
+		if i == 0 {
+			fmt.Fprintf(w, "Files:\t%s\n", f)
+		} else {
+			fmt.Fprintf(w, "\t%s\n", f)
+		}
+	}
+	return w.Flush()
+}
+
+func torrentfsVerify(ctx *cli.Context) error {
+	ih := torrentfsArg(ctx)
+	client := dialTorrentfs(ctx)
+	defer client.Close()
+
+	err := client.Call(nil, "torrentfsadmin_verify", ih)
+	if ctx.GlobalBool(torrentfsJSONFlag.Name) {
+		result := map[string]interface{}{"infoHash": ih, "valid": err == nil}
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		printJSON(result)
+		return nil
+	}
+	if err != nil {
+		utils.Fatalf("Verification failed: %v", err)
+	}
+	fmt.Println("All pieces of", ih, "verified")
+	return nil
+}
+
+func torrentfsRemove(ctx *cli.Context) error {
+	ih := torrentfsArg(ctx)
+	client := dialTorrentfs(ctx)
+	defer client.Close()
+
+	err := client.Call(nil, "torrentfsadmin_remove", ih)
+	if ctx.GlobalBool(torrentfsJSONFlag.Name) {
+		result := map[string]interface{}{"infoHash": ih, "removed": err == nil}
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		printJSON(result)
+		return nil
+	}
+	if err != nil {
+		utils.Fatalf("Failed to remove torrent: %v", err)
+	}
+	fmt.Println("Removed", ih)
+	return nil
+}
+
+func torrentfsExport(ctx *cli.Context) error {
+	format := ctx.String(torrentfsFormatFlag.Name)
+	if format != "csv" && format != "json" && format != "archive" {
+		utils.Fatalf("Unknown export format %q, want csv, json or archive", format)
+	}
+	out := os.Stdout
+	if path := ctx.String(torrentfsOutputFlag.Name); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			utils.Fatalf("Failed to create export file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+
+	if format == "archive" {
+		if !ctx.IsSet(torrentfsSignKeyFlag.Name) {
+			utils.Fatalf("An archive export needs --%s", torrentfsSignKeyFlag.Name)
+		}
+		key, err := crypto.LoadECDSA(ctx.String(torrentfsSignKeyFlag.Name))
+		if err != nil {
+			utils.Fatalf("Failed to load the signing key: %v", err)
+		}
+		count, err := torrentfs.WriteArchive(utils.MakeStorageDir(ctx), w, key)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			utils.Fatalf("Failed to export file storage: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d uploads, signed by %s\n", count, crypto.PubkeyToAddress(key.PublicKey).Hex())
+		return nil
+	}
+
+	var write func(*torrentfs.FileRecord) error
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		write = func(r *torrentfs.FileRecord) error { return enc.Encode(r) }
+	} else {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"number", "block", "tx", "infohash", "rawsize", "leftsize", "contract", "relate"})
+		write = func(r *torrentfs.FileRecord) error {
+			contract := ""
+			if r.Contract != nil {
+				contract = r.Contract.Hex()
+			}
+			relate := make([]string, len(r.Relate))
+			for i, addr := range r.Relate {
+				relate[i] = addr.Hex()
+			}
+			cw.Write([]string{
+				strconv.FormatUint(r.Number, 10),
+				r.Block.Hex(),
+				r.Tx.Hex(),
+				r.InfoHash,
+				strconv.FormatUint(r.RawSize, 10),
+				strconv.FormatUint(r.LeftSize, 10),
+				contract,
+				strings.Join(relate, ";"),
+			})
+			cw.Flush()
+			return cw.Error()
+		}
+	}
+	count := 0
+	err := torrentfs.ExportFiles(utils.MakeStorageDir(ctx), func(r *torrentfs.FileRecord) error {
+		count++
+		return write(r)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		utils.Fatalf("Failed to export file storage: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d uploads\n", count)
+	return nil
+}
+
+func torrentfsTorrent(ctx *cli.Context) error {
+	ih := torrentfsArg(ctx)
+	client := dialTorrentfs(ctx)
+	defer client.Close()
+
+	var data hexutil.Bytes
+	if err := client.Call(&data, "torrentfs_exportTorrent", ih); err != nil {
+		utils.Fatalf("Failed to export torrent: %v", err)
+	}
+	path := ctx.String(torrentfsOutputFlag.Name)
+	if path == "" {
+		path = strings.TrimPrefix(strings.ToLower(ih), "0x") + ".torrent"
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		utils.Fatalf("Failed to write torrent file: %v", err)
+	}
+	fmt.Println("Wrote", path)
+	return nil
+}
+
+// parseShape parses a comma separated shape, empty for none.
+func parseShape(s string) []uint64 {
+	if s == "" {
+		return nil
+	}
+	var shape []uint64
+	for _, dim := range strings.Split(s, ",") {
+		n, err := strconv.ParseUint(strings.TrimSpace(dim), 10, 64)
+		if err != nil {
+			utils.Fatalf("Invalid shape %q: %v", s, err)
+		}
+		shape = append(shape, n)
+	}
+	return shape
+}
+
+func torrentfsPublish(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires a model directory or input file as its argument")
+	}
+	path, err := filepath.Abs(ctx.Args().First())
+	if err != nil {
+		utils.Fatalf("Invalid path: %v", err)
+	}
+	args := torrentfs.PublishArgs{
+		Path:        path,
+		Kind:        ctx.String(torrentfsKindFlag.Name),
+		Comment:     ctx.String(torrentfsCommentFlag.Name),
+		InputShape:  parseShape(ctx.String(torrentfsInputShapeFlag.Name)),
+		OutputShape: parseShape(ctx.String(torrentfsOutputShapeFlag.Name)),
+		Shape:       parseShape(ctx.String(torrentfsShapeFlag.Name)),
+	}
+	version := ctx.Uint(torrentfsMetaVersionFlag.Name)
+	if version > math.MaxUint8 {
+		utils.Fatalf("Invalid meta version %d", version)
+	}
+	args.MetaVersion = uint8(version)
+	if author := ctx.String(torrentfsAuthorFlag.Name); author != "" {
+		if !common.IsHexAddress(author) {
+			utils.Fatalf("Invalid author address %q", author)
+		}
+		args.Author = common.HexToAddress(author)
+	}
+	client := dialTorrentfs(ctx)
+	defer client.Close()
+
+	var pub torrentfs.Publication
+	if err := client.Call(&pub, "torrentfsadmin_publish", args); err != nil {
+		utils.Fatalf("Failed to publish: %v", err)
+	}
+	if ctx.GlobalBool(torrentfsJSONFlag.Name) {
+		printJSON(pub)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Info hash:\t%s\n", pub.InfoHash)
+	fmt.Fprintf(w, "Kind:\t%s\n", pub.Kind)
+	fmt.Fprintf(w, "Size:\t%v\n", common.StorageSize(pub.RawSize))
+	fmt.Fprintf(w, "Payload:\t%s\n", pub.Payload)
+	fmt.Fprintf(w, "Uploads:\t%d (gas %d each)\n", pub.Uploads, pub.UploadGas)
+	return w.Flush()
+}
@@ -168,6 +168,8 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.ListenPortFlag,
 			utils.MaxPeersFlag,
 			utils.MaxPendingPeersFlag,
+			utils.BandwidthFlag,
+			utils.BandwidthShareFlag,
 			utils.NATFlag,
 			utils.NoDiscoverFlag,
 			utils.DiscoveryV5Flag,
@@ -202,6 +204,21 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.InferDeviceIdFlag,
 			utils.InferPortFlag,
 			utils.InferMemoryFlag,
+			utils.InferAuditLogFlag,
+			utils.InferDumpFlag,
+			utils.InferBackendsFlag,
+			utils.InferSandboxFlag,
+			utils.InferSandboxMemoryFlag,
+			utils.InferSandboxOutputFlag,
+			utils.InferSandboxCgroupFlag,
+			utils.InferWorkersFlag,
+			utils.InferTimeoutFlag,
+			utils.InferDeterministicFlag,
+			utils.InferDevicesFlag,
+			utils.InferDeviceQueueFlag,
+			utils.InferCacheFlag,
+			utils.InferCacheJournalFlag,
+			utils.InferWarmLoadFlag,
 		},
 	},
 	{
@@ -213,11 +230,82 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.StoragePortFlag,
 			utils.StorageMaxSeedingFlag,
 			utils.StorageMaxActiveFlag,
+			utils.StorageMaxStartingFlag,
+			utils.StorageMaxActiveDownloadsFlag,
 			//utils.StorageBoostNodesFlag,
 			utils.StorageTrackerFlag,
 			utils.StorageDisableDHTFlag,
+			utils.StorageDisableIPv4Flag,
+			utils.StorageDisableIPv6Flag,
 			utils.StorageDisableTCPFlag,
 			utils.StorageFullFlag,
+			utils.StorageQuotaFlag,
+			utils.StorageMinFreeFlag,
+			utils.StorageHealthAddrFlag,
+			utils.StorageControlAddrFlag,
+			utils.StoragePublishDirFlag,
+			utils.StorageTraceEndpointFlag,
+			utils.StorageEndpointsFlag,
+			utils.StorageWatchFlag,
+			utils.StorageConfirmationsFlag,
+			utils.StorageUploadRateFlag,
+			utils.StorageFairUploadFlag,
+			utils.StorageRecentWeightFlag,
+			utils.StorageBandwidthShareFlag,
+			utils.StoragePortRangeFlag,
+			utils.StorageNATFlag,
+			utils.StorageExternalPortFlag,
+			utils.StorageProxyFlag,
+			utils.StorageProxyOnlyFlag,
+			utils.StorageBlocklistFlag,
+			utils.StorageBlocklistRefreshFlag,
+			utils.StorageSwarmFlag,
+			utils.StorageSwarmSecretFlag,
+			utils.StorageMaxConnsFlag,
+			utils.StorageConnsPerTorrentFlag,
+			utils.StorageHalfOpenPerTorrentFlag,
+			utils.StorageDialTimeoutFlag,
+			utils.StorageMinDialTimeoutFlag,
+			utils.StorageHandshakeTimeoutFlag,
+			utils.StorageLogIntervalFlag,
+			utils.StorageLogLevelFlag,
+			utils.StorageIndexOnlyFlag,
+			utils.StoragePeerIDPrefixFlag,
+			utils.StoragePeerIDFlag,
+			utils.StorageClientVersionFlag,
+			utils.StorageUserAgentFlag,
+			utils.StorageEncryptionFlag,
+			utils.StorageAllocationFlag,
+			utils.StorageLayoutFlag,
+			utils.StorageFailurePolicyFlag,
+			utils.StorageResetFlag,
+			utils.StorageColdDirFlag,
+			utils.StorageTierPolicyFlag,
+			utils.StorageTierIdleFlag,
+			utils.StorageTierHighFlag,
+			utils.StorageTierLowFlag,
+			utils.StorageObjectStoreFlag,
+			utils.StorageObjectEndpointFlag,
+			utils.StorageObjectRegionFlag,
+			utils.StorageSyncBatchFlag,
+			utils.StorageSyncIntervalFlag,
+			utils.StoragePollIntervalFlag,
+			utils.StorageRetryIntervalFlag,
+			utils.StorageRetryMaxIntervalFlag,
+			utils.StorageRetryAttemptsFlag,
+			utils.StorageReconcileIntervalFlag,
+			utils.StoragePruneFlag,
+			utils.StoragePruneWindowFlag,
+			utils.StorageMaxRewindFlag,
+			utils.StorageWriteBatchFlag,
+			utils.StorageArchiveFlag,
+			utils.StorageArchiveSignerFlag,
+			utils.StoragePopularityWindowFlag,
+			utils.StoragePopularModelsFlag,
+			utils.StorageScrapeIntervalFlag,
+			utils.StorageCoordinateFlag,
+			utils.StoragePieceStrategyFlag,
+			utils.StorageMaintenanceFlag,
 			//utils.StorageBoostFlag,
 		},
 	},
@@ -230,6 +230,16 @@ var (
 		Usage: "The maximum number of active tasks in the same time",
 		Value: torrentfs.DefaultConfig.MaxActiveNum,
 	}
+	StorageMaxStartingFlag = cli.IntFlag{
+		Name:  "storage.max_starting",
+		Usage: "The maximum number of new tasks looking up their metadata in the same time (0 = unbounded)",
+		Value: torrentfs.DefaultConfig.MaxStarting,
+	}
+	StorageMaxActiveDownloadsFlag = cli.IntFlag{
+		Name:  "storage.max_active_downloads",
+		Usage: "The maximum number of tasks downloading in the same time, the others wait queued (0 = unbounded)",
+		Value: torrentfs.DefaultConfig.MaxActiveDownloads,
+	}
 	StorageBoostNodesFlag = cli.StringFlag{
 		Name:  "storage.boostnodes",
 		Usage: "p2p storage boostnodes (EXPERIMENTAL)",
@@ -244,6 +254,14 @@ var (
 		Name:  "storage.disable_dht",
 		Usage: "disable DHT network in FS",
 	}
+	StorageDisableIPv4Flag = cli.BoolFlag{
+		Name:  "storage.disable_ipv4",
+		Usage: "disable IPv4 peers and DHT node in FS",
+	}
+	StorageDisableIPv6Flag = cli.BoolFlag{
+		Name:  "storage.disable_ipv6",
+		Usage: "disable IPv6 peers and DHT node in FS",
+	}
 	StorageBoostFlag = cli.BoolFlag{
 		Name:  "storage.boost",
 		Usage: "Boost fs (EXPERIMENTAL)",
@@ -260,6 +278,311 @@ var (
 		Name:  "storage.debug",
 		Usage: "debug mod for nas",
 	}
+	StorageQuotaFlag = cli.Uint64Flag{
+		Name:  "storage.quota",
+		Usage: "Maximum disk space used by P2P storage in megabytes (0 = unlimited)",
+	}
+	StorageMinFreeFlag = cli.Uint64Flag{
+		Name:  "storage.min_free",
+		Usage: "Free disk space in megabytes below which P2P storage pauses its downloads (0 = no guard)",
+		Value: torrentfs.DefaultConfig.MinFreeSpace / 1024 / 1024,
+	}
+	StorageHealthAddrFlag = cli.StringFlag{
+		Name:  "storage.health_addr",
+		Usage: "HTTP listening address of the storage /healthz endpoint (disabled if empty)",
+	}
+	StorageTraceEndpointFlag = cli.StringFlag{
+		Name:  "storage.trace_endpoint",
+		Usage: "OTLP/HTTP traces url of an OpenTelemetry collector the storage sync is traced to, e.g. http://localhost:4318/v1/traces (disabled if empty)",
+	}
+	StorageControlAddrFlag = cli.StringFlag{
+		Name:  "storage.control_addr",
+		Usage: "HTTP listening address of the unauthenticated storage control API under /v1/ (disabled if empty)",
+	}
+	StoragePublishDirFlag = DirectoryFlag{
+		Name:  "storage.publish_dir",
+		Usage: "Directory the models and inputs published over rpc are taken from (publishing disabled if empty)",
+	}
+	StorageEndpointsFlag = cli.StringFlag{
+		Name:  "storage.endpoints",
+		Usage: "Comma separated fallback rpc/ipc endpoints to sync storage from when the primary node is down",
+	}
+	StorageWatchFlag = cli.StringFlag{
+		Name:  "storage.watch",
+		Usage: "Comma separated contract addresses whose transactions are reported, each optionally followed by =url of a webhook",
+	}
+	StorageConfirmationsFlag = cli.Uint64Flag{
+		Name:  "storage.confirmations",
+		Usage: "Blocks an upload transaction must be buried under before storage acts on it",
+		Value: torrentfs.DefaultConfig.Confirmations,
+	}
+	StorageUploadRateFlag = cli.IntFlag{
+		Name:  "storage.upload_rate",
+		Usage: "Upload rate limit of the storage in bytes per second (-1 = unlimited)",
+		Value: torrentfs.DefaultConfig.UploadRate,
+	}
+	StorageFairUploadFlag = cli.BoolFlag{
+		Name:  "storage.fair_upload",
+		Usage: "Split the upload rate limit across seeding torrents by weight",
+	}
+	StorageRecentWeightFlag = cli.IntFlag{
+		Name:  "storage.recent_weight",
+		Usage: "Upload weight of recently downloaded and hot torrents relative to the others",
+		Value: torrentfs.DefaultConfig.RecentWeight,
+	}
+	StorageBandwidthShareFlag = cli.IntFlag{
+		Name:  "storage.bandwidth",
+		Usage: "Weight of the storage in the split of --bandwidth (0 = not capped by it)",
+	}
+	StoragePortRangeFlag = cli.IntFlag{
+		Name:  "storage.port_range",
+		Usage: "Number of ports above storage.port to try if it is busy",
+		Value: torrentfs.DefaultConfig.PortRange,
+	}
+	StorageNATFlag = cli.StringFlag{
+		Name:  "storage.nat",
+		Usage: "Storage port mapping mechanism (any|none|upnp|pmp|extip:<IP>)",
+		Value: torrentfs.DefaultConfig.NAT,
+	}
+	StorageExternalPortFlag = cli.IntFlag{
+		Name:  "storage.external_port",
+		Usage: "Manually forwarded storage port announced to trackers and the DHT (disables port mapping)",
+	}
+	StorageProxyFlag = cli.StringFlag{
+		Name:  "storage.proxy",
+		Usage: "Proxy for storage peer connections, trackers and boost nodes (socks5://[user:pass@]host:port or http://host:port)",
+	}
+	StorageProxyOnlyFlag = cli.BoolFlag{
+		Name:  "storage.proxy_only",
+		Usage: "Disable the storage dht, utp and udp trackers, which can't go through the proxy",
+	}
+	StorageBlocklistFlag = cli.StringFlag{
+		Name:  "storage.blocklist",
+		Usage: "Path or URL of an IP blocklist for storage peers (P2P or DAT format, optionally gzipped)",
+	}
+	StorageBlocklistRefreshFlag = cli.DurationFlag{
+		Name:  "storage.blocklist_refresh",
+		Usage: "Interval between reloads of the storage blocklist",
+		Value: torrentfs.DefaultConfig.BlocklistRefresh,
+	}
+	StorageSwarmFlag = cli.StringFlag{
+		Name:  "storage.swarm",
+		Usage: "Comma separated host:port of the only storage peers of a private swarm (disables dht, pex and trackers)",
+	}
+	StorageSwarmSecretFlag = cli.StringFlag{
+		Name:  "storage.swarm.secret",
+		Usage: "Shared secret the peers of the private swarm prove on every connection",
+	}
+	StorageMaxConnsFlag = cli.IntFlag{
+		Name:  "storage.max_conns",
+		Usage: "Maximum number of storage peer connections over all torrents (0 = derive from the file descriptor limit, -1 = unlimited)",
+	}
+	StorageConnsPerTorrentFlag = cli.IntFlag{
+		Name:  "storage.conns_per_torrent",
+		Usage: "Maximum number of established peer connections per torrent",
+		Value: torrentfs.DefaultConfig.EstablishedConnsPerTorrent,
+	}
+	StorageHalfOpenPerTorrentFlag = cli.IntFlag{
+		Name:  "storage.halfopen_per_torrent",
+		Usage: "Maximum number of half-open peer connections per torrent",
+		Value: torrentfs.DefaultConfig.HalfOpenConnsPerTorrent,
+	}
+	StorageDialTimeoutFlag = cli.DurationFlag{
+		Name:  "storage.dial_timeout",
+		Usage: "Peer dial timeout of the storage, raise it on high-latency links",
+		Value: torrentfs.DefaultConfig.DialTimeout,
+	}
+	StorageMinDialTimeoutFlag = cli.DurationFlag{
+		Name:  "storage.min_dial_timeout",
+		Usage: "Floor of the peer dial timeout of the storage while many dials are pending",
+		Value: torrentfs.DefaultConfig.MinDialTimeout,
+	}
+	StorageHandshakeTimeoutFlag = cli.DurationFlag{
+		Name:  "storage.handshake_timeout",
+		Usage: "Time a storage peer has to complete the handshake",
+		Value: torrentfs.DefaultConfig.HandshakeTimeout,
+	}
+	StorageLogIntervalFlag = cli.DurationFlag{
+		Name:  "storage.log_interval",
+		Usage: "Sampling interval of repetitive storage sync logs and of the sync progress summary (0 = log every line)",
+		Value: torrentfs.DefaultConfig.LogInterval,
+	}
+	StorageLogLevelFlag = cli.StringFlag{
+		Name:  "storage.log_level",
+		Usage: "Log level of the per block storage sync events (trace|debug|info|warn|error)",
+		Value: torrentfs.DefaultConfig.LogLevel,
+	}
+	StorageIndexOnlyFlag = cli.BoolFlag{
+		Name:  "storage.index_only",
+		Usage: "Index the uploaded files of the chain without downloading or seeding them",
+	}
+	StoragePeerIDPrefixFlag = cli.StringFlag{
+		Name:  "storage.peerid_prefix",
+		Usage: "Peer id prefix of the storage client (BEP 20)",
+		Value: torrentfs.DefaultConfig.Bep20,
+	}
+	StoragePeerIDFlag = cli.StringFlag{
+		Name:  "storage.peerid",
+		Usage: "Full 20 byte peer id of the storage client, overriding the prefix",
+	}
+	StorageClientVersionFlag = cli.StringFlag{
+		Name:  "storage.client_version",
+		Usage: "Client version announced in the storage extended handshake",
+		Value: torrentfs.DefaultConfig.ClientVersion,
+	}
+	StorageUserAgentFlag = cli.StringFlag{
+		Name:  "storage.user_agent",
+		Usage: "User agent of storage tracker and boost node requests",
+		Value: torrentfs.DefaultConfig.UserAgent,
+	}
+	StorageEncryptionFlag = cli.StringFlag{
+		Name:  "storage.encryption",
+		Usage: "Encryption policy of storage peer connections (prefer|force|prefer-plain|disable)",
+		Value: torrentfs.DefaultConfig.Encryption,
+	}
+	StorageAllocationFlag = cli.StringFlag{
+		Name:  "storage.allocation",
+		Usage: "Disk allocation of storage downloads (sparse|full), full reserves the whole size up front",
+		Value: torrentfs.DefaultConfig.Allocation,
+	}
+	StorageLayoutFlag = cli.StringFlag{
+		Name:  "storage.layout",
+		Usage: "Data directory layout (infohash|address), address also links completed files by upload contract address",
+		Value: torrentfs.DefaultConfig.Layout,
+	}
+	StorageFailurePolicyFlag = cli.StringFlag{
+		Name:  "storage.failure_policy",
+		Usage: "Action once storage sync can't be started (log|stop-service|stop-node)",
+		Value: torrentfs.DefaultConfig.FailurePolicy,
+	}
+	StorageResetFlag = cli.BoolFlag{
+		Name:  "storage.reset",
+		Usage: "Drop the storage metadata of another chain instead of refusing to sync",
+	}
+	StorageColdDirFlag = DirectoryFlag{
+		Name:  "storage.cold_dir",
+		Usage: "Cold tier directory completed but idle files are moved to (disabled if empty)",
+	}
+	StorageTierPolicyFlag = cli.StringFlag{
+		Name:  "storage.tier_policy",
+		Usage: "When files are moved to the cold tier (idle|watermark)",
+		Value: torrentfs.DefaultConfig.TierPolicy,
+	}
+	StorageTierIdleFlag = cli.DurationFlag{
+		Name:  "storage.tier_idle",
+		Usage: "Time since a file was last read before it is moved to the cold tier",
+		Value: torrentfs.DefaultConfig.TierIdle,
+	}
+	StorageTierHighFlag = cli.Uint64Flag{
+		Name:  "storage.tier_high",
+		Usage: "Hot tier size in megabytes above which idle files are moved out (watermark policy)",
+	}
+	StorageTierLowFlag = cli.Uint64Flag{
+		Name:  "storage.tier_low",
+		Usage: "Hot tier size in megabytes moving files out stops at (watermark policy)",
+	}
+	StorageObjectStoreFlag = cli.StringFlag{
+		Name:  "storage.object_store",
+		Usage: "S3 location pieces are stored in instead of the local disk, e.g. s3://bucket/prefix (credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)",
+	}
+	StorageObjectEndpointFlag = cli.StringFlag{
+		Name:  "storage.object_endpoint",
+		Usage: "URL of an S3 compatible object store (default AWS)",
+	}
+	StorageObjectRegionFlag = cli.StringFlag{
+		Name:  "storage.object_region",
+		Usage: "Region object store requests are signed for (default us-east-1)",
+	}
+	StorageSyncBatchFlag = cli.Uint64Flag{
+		Name:  "storage.sync_batch",
+		Usage: "Blocks queued for scanning, a sync round covers up to 8 batches (16-65536)",
+		Value: torrentfs.DefaultConfig.SyncBatch,
+	}
+	StorageSyncIntervalFlag = cli.DurationFlag{
+		Name:  "storage.sync_interval",
+		Usage: "Pause between storage sync rounds once caught up with the chain",
+		Value: torrentfs.DefaultConfig.SyncInterval,
+	}
+	StoragePollIntervalFlag = cli.DurationFlag{
+		Name:  "storage.poll_interval",
+		Usage: "Chain head polling interval of a local node, ten times longer for remote nodes",
+		Value: torrentfs.DefaultConfig.PollInterval,
+	}
+	StorageRetryIntervalFlag = cli.DurationFlag{
+		Name:  "storage.retry_interval",
+		Usage: "First delay of retries reaching the upstream node",
+		Value: torrentfs.DefaultConfig.RetryInterval,
+	}
+	StorageRetryMaxIntervalFlag = cli.DurationFlag{
+		Name:  "storage.retry_max_interval",
+		Usage: "Bound of the delay between retries of block and receipt fetches",
+		Value: torrentfs.DefaultConfig.RetryMaxInterval,
+	}
+	StorageRetryAttemptsFlag = cli.IntFlag{
+		Name:  "storage.retry_attempts",
+		Usage: "Attempts of a block or receipt fetch before the block is left for the next sync round",
+		Value: torrentfs.DefaultConfig.FetchAttempts,
+	}
+	StorageReconcileIntervalFlag = cli.DurationFlag{
+		Name:  "storage.reconcile_interval",
+		Usage: "Interval the progress of incomplete uploads is read from the chain again (0 = disabled)",
+		Value: torrentfs.DefaultConfig.ReconcileInterval,
+	}
+	StoragePruneFlag = cli.BoolFlag{
+		Name:  "storage.prune",
+		Usage: "Delete old storage blocks that only carry upload progress",
+	}
+	StoragePruneWindowFlag = cli.Uint64Flag{
+		Name:  "storage.prune_window",
+		Usage: "Recent storage blocks kept by pruning, for reorgs",
+		Value: torrentfs.DefaultConfig.PruneWindow,
+	}
+	StorageArchiveFlag = cli.StringFlag{
+		Name:  "storage.archive",
+		Usage: "Path or URL of a signed upload archive an empty file storage is filled from",
+	}
+	StorageArchiveSignerFlag = cli.StringFlag{
+		Name:  "storage.archive_signer",
+		Usage: "Address the upload archive must be signed by",
+	}
+	StorageWriteBatchFlag = cli.IntFlag{
+		Name:  "storage.write_batch",
+		Usage: "Recorded blocks committed to the storage database at once (1 commits each block)",
+		Value: torrentfs.DefaultConfig.WriteBatch,
+	}
+	StorageMaxRewindFlag = cli.Uint64Flag{
+		Name:  "storage.max_rewind",
+		Usage: "Deepest chain reorganisation the storage sync is rewound for",
+		Value: torrentfs.DefaultConfig.MaxRewind,
+	}
+	StoragePopularityWindowFlag = cli.Uint64Flag{
+		Name:  "storage.popularity_window",
+		Usage: "Blocks the inference references to models are counted over",
+		Value: torrentfs.DefaultConfig.PopularityWindow,
+	}
+	StoragePopularModelsFlag = cli.IntFlag{
+		Name:  "storage.popular_models",
+		Usage: "Number of most referenced models kept seeding and in the hot storage tier",
+		Value: torrentfs.DefaultConfig.PopularModels,
+	}
+	StorageScrapeIntervalFlag = cli.DurationFlag{
+		Name:  "storage.scrape_interval",
+		Usage: "Interval trackers are scraped and the dht sampled to estimate the swarm of each file (0 disables)",
+		Value: torrentfs.DefaultConfig.ScrapeInterval,
+	}
+	StoragePieceStrategyFlag = cli.StringFlag{
+		Name:  "storage.piece_strategy",
+		Usage: "Order pieces are fetched in: rarest, sequential, or deadline (rarest until a block waits on the file)",
+		Value: torrentfs.DefaultConfig.PieceStrategy,
+	}
+	StorageMaintenanceFlag = cli.StringFlag{
+		Name:  "storage.maintenance",
+		Usage: "Cron expression of the storage maintenance window, e.g. \"30 3 * * 0\" (downloads paused while the database is compacted and seeded pieces verified)",
+	}
+	StorageCoordinateFlag = cli.BoolFlag{
+		Name:  "storage.coordinate",
+		Usage: "Stand by while another instance holds the storage directory, and take over when it stops",
+	}
 	// Dashboard settings
 	// DashboardEnabledFlag = cli.BoolFlag{
 	// 	Name:  metrics.DashboardEnabledFlag,
@@ -482,6 +805,72 @@ var (
 		Usage: "the maximum memory usage of infer engine, use --infer.memory=4096. shoule at least be 2048 (MiB)",
 		Value: int(synapse.DefaultConfig.MaxMemoryUsage >> 20),
 	}
+	InferCacheFlag = cli.IntFlag{
+		Name:  "infer.cache",
+		Usage: "Number of inference results kept in memory",
+		Value: synapse.DefaultConfig.ResultCacheSize,
+	}
+	InferCacheJournalFlag = cli.StringFlag{
+		Name:  "infer.cache.journal",
+		Usage: "Disk journal for the inference result cache to survive node restarts (empty to disable)",
+	}
+	InferWarmLoadFlag = cli.BoolFlag{
+		Name:  "infer.warmload",
+		Usage: "Load models into the inference cache as soon as their download completes, if they fit the free device memory",
+	}
+	InferDevicesFlag = cli.StringFlag{
+		Name:  "infer.devices",
+		Usage: "the devices inference is scheduled on, use --infer.devices=0,1, overrides --infer.device",
+	}
+	InferDeviceQueueFlag = cli.IntFlag{
+		Name:  "infer.device.queue",
+		Usage: "pending inferences on a device before models are also loaded on other devices",
+		Value: synapse.DefaultConfig.DeviceQueue,
+	}
+	InferDeterministicFlag = cli.BoolFlag{
+		Name:  "infer.deterministic",
+		Usage: "verify models only use deterministic integer operators and run them on the cpu kernels",
+	}
+	InferWorkersFlag = cli.IntFlag{
+		Name:  "infer.workers",
+		Usage: "maximum number of inferences running at once",
+		Value: synapse.DefaultConfig.Workers,
+	}
+	InferTimeoutFlag = cli.DurationFlag{
+		Name:  "infer.timeout",
+		Usage: "deadline of a single inference, including its time in the queue (0 = no deadline)",
+		Value: synapse.DefaultConfig.InferTimeout,
+	}
+	InferAuditLogFlag = cli.StringFlag{
+		Name:  "infer.audit",
+		Usage: "append-only log recording every consensus inference (empty to disable)",
+	}
+	InferDumpFlag = cli.StringFlag{
+		Name:  "infer.dump",
+		Usage: "directory receiving a replayable dump of every consensus inference (debugging only, empty to disable)",
+	}
+	InferBackendsFlag = cli.StringFlag{
+		Name:  "infer.backends",
+		Usage: "comma separated inference backends in order of preference, each model runs on the first one supporting it",
+		Value: synapse.DefaultBackend,
+	}
+	InferSandboxFlag = cli.BoolFlag{
+		Name:  "infer.sandbox",
+		Usage: "run native inferences in worker processes killed at their limits",
+	}
+	InferSandboxMemoryFlag = cli.IntFlag{
+		Name:  "infer.sandbox.memory",
+		Usage: "memory limit of a sandbox worker in megabytes (0 = no limit)",
+	}
+	InferSandboxOutputFlag = cli.IntFlag{
+		Name:  "infer.sandbox.output",
+		Usage: "maximum output size of a sandboxed inference in bytes",
+		Value: 1 << 20,
+	}
+	InferSandboxCgroupFlag = cli.StringFlag{
+		Name:  "infer.sandbox.cgroup",
+		Usage: "delegated cgroup v2 directory enforcing the sandbox memory limit (empty = rlimit)",
+	}
 
 	// Account settings
 	UnlockedAccountFlag = cli.StringFlag{
@@ -613,6 +1002,15 @@ var (
 		Usage: "Maximum number of pending connection attempts (defaults used if set to 0)",
 		Value: 0,
 	}
+	BandwidthFlag = cli.IntFlag{
+		Name:  "bandwidth",
+		Usage: "Traffic cap of the node in bytes per second and direction, shared by devp2p and storage (0 = unlimited)",
+	}
+	BandwidthShareFlag = cli.IntFlag{
+		Name:  "bandwidth.p2p",
+		Usage: "Weight of devp2p in the split of --bandwidth",
+		Value: 1,
+	}
 	ListenPortFlag = cli.IntFlag{
 		Name:  "port",
 		Usage: "Network listening port (mainnet: '40404' dolores: '40405' bernard: '40406')",
@@ -1063,6 +1461,12 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 	if ctx.GlobalIsSet(MaxPendingPeersFlag.Name) {
 		cfg.MaxPendingPeers = ctx.GlobalInt(MaxPendingPeersFlag.Name)
 	}
+	if ctx.GlobalIsSet(BandwidthFlag.Name) {
+		cfg.MaxBandwidth = ctx.GlobalInt(BandwidthFlag.Name)
+	}
+	if ctx.GlobalIsSet(BandwidthShareFlag.Name) {
+		cfg.BandwidthShare = ctx.GlobalInt(BandwidthShareFlag.Name)
+	}
 	if ctx.GlobalIsSet(NoDiscoverFlag.Name) {
 		cfg.NoDiscovery = true
 	}
@@ -1400,6 +1804,40 @@ func SetCortexConfig(ctx *cli.Context, stack *node.Node, cfg *ctxc.Config) {
 		panic(fmt.Sprintf("invalid device: %s", cfg.InferDeviceType))
 	}
 	cfg.InferDeviceId = ctx.GlobalInt(InferDeviceIdFlag.Name)
+	if devices := ctx.GlobalString(InferDevicesFlag.Name); devices != "" {
+		for _, dev := range strings.Split(devices, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(dev))
+			if err != nil {
+				Fatalf("Invalid inference device %q: %v", dev, err)
+			}
+			cfg.InferDeviceIds = append(cfg.InferDeviceIds, id)
+		}
+	}
+	cfg.InferDeviceQueue = ctx.GlobalInt(InferDeviceQueueFlag.Name)
+	cfg.InferDeterministic = ctx.GlobalBool(InferDeterministicFlag.Name)
+	cfg.InferWorkers = ctx.GlobalInt(InferWorkersFlag.Name)
+	cfg.InferTimeout = ctx.GlobalDuration(InferTimeoutFlag.Name)
+	if ctx.GlobalIsSet(InferAuditLogFlag.Name) {
+		cfg.InferAuditLog = ctx.GlobalString(InferAuditLogFlag.Name)
+	}
+	if ctx.GlobalIsSet(InferDumpFlag.Name) {
+		cfg.InferDumpDir = ctx.GlobalString(InferDumpFlag.Name)
+	}
+	if ctx.GlobalIsSet(InferBackendsFlag.Name) {
+		cfg.InferBackends = splitAndTrim(ctx.GlobalString(InferBackendsFlag.Name))
+	}
+	if ctx.GlobalIsSet(InferSandboxFlag.Name) {
+		cfg.InferSandbox = ctx.GlobalBool(InferSandboxFlag.Name)
+	}
+	if ctx.GlobalIsSet(InferSandboxMemoryFlag.Name) {
+		cfg.InferSandboxMemory = int64(ctx.GlobalInt(InferSandboxMemoryFlag.Name)) * 1024 * 1024
+	}
+	if ctx.GlobalIsSet(InferSandboxOutputFlag.Name) {
+		cfg.InferSandboxOutput = ctx.GlobalInt(InferSandboxOutputFlag.Name)
+	}
+	if ctx.GlobalIsSet(InferSandboxCgroupFlag.Name) {
+		cfg.InferSandboxCgroup = ctx.GlobalString(InferSandboxCgroupFlag.Name)
+	}
 	mem, err := gopsutil.VirtualMemory()
 	if err == nil {
 		if 32<<(^uintptr(0)>>63) == 32 && mem.Total > 2*1024*1024*1024 {
@@ -1414,6 +1852,13 @@ func SetCortexConfig(ctx *cli.Context, stack *node.Node, cfg *ctxc.Config) {
 	}
 	cfg.InferMemoryUsage = int64(ctx.GlobalInt(InferMemoryFlag.Name))
 	cfg.InferMemoryUsage = cfg.InferMemoryUsage << 20
+	cfg.InferCacheSize = ctx.GlobalInt(InferCacheFlag.Name)
+	if ctx.GlobalIsSet(InferCacheJournalFlag.Name) {
+		cfg.InferCacheJournal = ctx.GlobalString(InferCacheJournalFlag.Name)
+	}
+	if ctx.GlobalIsSet(InferWarmLoadFlag.Name) {
+		cfg.InferWarmLoad = ctx.GlobalBool(InferWarmLoadFlag.Name)
+	}
 	//log.Warn("C MEMORY FOR CVM", "cache", cfg.InferMemoryUsage)
 	// Override any default configs for hard coded networks.
 	switch {
@@ -1499,6 +1944,62 @@ func setDNSDiscoveryDefaults(cfg *ctxc.Config, genesis common.Hash) {
 func SetTorrentFsConfig(ctx *cli.Context, cfg *torrentfs.Config) {
 	//	cfg.Host = ctx.GlobalString(StorageAddrFlag.Name)
 	cfg.Port = ctx.GlobalInt(StoragePortFlag.Name)
+	cfg.PortRange = ctx.GlobalInt(StoragePortRangeFlag.Name)
+	cfg.NAT = ctx.GlobalString(StorageNATFlag.Name)
+	cfg.ExternalPort = ctx.GlobalInt(StorageExternalPortFlag.Name)
+	cfg.Proxy = ctx.GlobalString(StorageProxyFlag.Name)
+	cfg.ProxyOnly = ctx.GlobalBool(StorageProxyOnlyFlag.Name)
+	cfg.Blocklist = ctx.GlobalString(StorageBlocklistFlag.Name)
+	cfg.BlocklistRefresh = ctx.GlobalDuration(StorageBlocklistRefreshFlag.Name)
+	if swarm := ctx.GlobalString(StorageSwarmFlag.Name); swarm != "" {
+		cfg.SwarmPeers = strings.Split(swarm, ",")
+	}
+	cfg.SwarmSecret = ctx.GlobalString(StorageSwarmSecretFlag.Name)
+	cfg.MaxConns = ctx.GlobalInt(StorageMaxConnsFlag.Name)
+	cfg.EstablishedConnsPerTorrent = ctx.GlobalInt(StorageConnsPerTorrentFlag.Name)
+	cfg.DialTimeout = ctx.GlobalDuration(StorageDialTimeoutFlag.Name)
+	cfg.MinDialTimeout = ctx.GlobalDuration(StorageMinDialTimeoutFlag.Name)
+	cfg.HandshakeTimeout = ctx.GlobalDuration(StorageHandshakeTimeoutFlag.Name)
+	cfg.HalfOpenConnsPerTorrent = ctx.GlobalInt(StorageHalfOpenPerTorrentFlag.Name)
+	cfg.LogInterval = ctx.GlobalDuration(StorageLogIntervalFlag.Name)
+	cfg.LogLevel = ctx.GlobalString(StorageLogLevelFlag.Name)
+	cfg.IndexOnly = ctx.GlobalBool(StorageIndexOnlyFlag.Name)
+	cfg.Bep20 = ctx.GlobalString(StoragePeerIDPrefixFlag.Name)
+	cfg.PeerID = ctx.GlobalString(StoragePeerIDFlag.Name)
+	cfg.ClientVersion = ctx.GlobalString(StorageClientVersionFlag.Name)
+	cfg.UserAgent = ctx.GlobalString(StorageUserAgentFlag.Name)
+	cfg.Encryption = ctx.GlobalString(StorageEncryptionFlag.Name)
+	cfg.Allocation = ctx.GlobalString(StorageAllocationFlag.Name)
+	cfg.Layout = ctx.GlobalString(StorageLayoutFlag.Name)
+	cfg.FailurePolicy = ctx.GlobalString(StorageFailurePolicyFlag.Name)
+	cfg.ResetChain = ctx.GlobalBool(StorageResetFlag.Name)
+	cfg.ColdDataDir = ctx.GlobalString(StorageColdDirFlag.Name)
+	cfg.TierPolicy = ctx.GlobalString(StorageTierPolicyFlag.Name)
+	cfg.TierIdle = ctx.GlobalDuration(StorageTierIdleFlag.Name)
+	cfg.TierHighWatermark = ctx.GlobalUint64(StorageTierHighFlag.Name) * 1024 * 1024
+	cfg.TierLowWatermark = ctx.GlobalUint64(StorageTierLowFlag.Name) * 1024 * 1024
+	cfg.ObjectStore = ctx.GlobalString(StorageObjectStoreFlag.Name)
+	cfg.ObjectEndpoint = ctx.GlobalString(StorageObjectEndpointFlag.Name)
+	cfg.ObjectRegion = ctx.GlobalString(StorageObjectRegionFlag.Name)
+	cfg.SyncBatch = ctx.GlobalUint64(StorageSyncBatchFlag.Name)
+	cfg.SyncInterval = ctx.GlobalDuration(StorageSyncIntervalFlag.Name)
+	cfg.PollInterval = ctx.GlobalDuration(StoragePollIntervalFlag.Name)
+	cfg.RetryInterval = ctx.GlobalDuration(StorageRetryIntervalFlag.Name)
+	cfg.RetryMaxInterval = ctx.GlobalDuration(StorageRetryMaxIntervalFlag.Name)
+	cfg.FetchAttempts = ctx.GlobalInt(StorageRetryAttemptsFlag.Name)
+	cfg.ReconcileInterval = ctx.GlobalDuration(StorageReconcileIntervalFlag.Name)
+	cfg.Prune = ctx.GlobalBool(StoragePruneFlag.Name)
+	cfg.PruneWindow = ctx.GlobalUint64(StoragePruneWindowFlag.Name)
+	cfg.MaxRewind = ctx.GlobalUint64(StorageMaxRewindFlag.Name)
+	cfg.WriteBatch = ctx.GlobalInt(StorageWriteBatchFlag.Name)
+	cfg.Archive = ctx.GlobalString(StorageArchiveFlag.Name)
+	cfg.ArchiveSigner = ctx.GlobalString(StorageArchiveSignerFlag.Name)
+	cfg.PopularityWindow = ctx.GlobalUint64(StoragePopularityWindowFlag.Name)
+	cfg.PopularModels = ctx.GlobalInt(StoragePopularModelsFlag.Name)
+	cfg.ScrapeInterval = ctx.GlobalDuration(StorageScrapeIntervalFlag.Name)
+	cfg.Coordinate = ctx.GlobalBool(StorageCoordinateFlag.Name)
+	cfg.PieceStrategy = ctx.GlobalString(StoragePieceStrategyFlag.Name)
+	cfg.MaintenanceSchedule = ctx.GlobalString(StorageMaintenanceFlag.Name)
 	IPCDisabled := ctx.GlobalBool(IPCDisabledFlag.Name)
 	if runtime.GOOS == "windows" || IPCDisabled {
 		cfg.IpcPath = ""
@@ -1509,6 +2010,12 @@ func SetTorrentFsConfig(ctx *cli.Context, cfg *torrentfs.Config) {
 		cfg.IpcPath = filepath.Join(path, IPCPath)
 	}
 	cfg.RpcURI = ctx.GlobalString(StorageRpcFlag.Name)
+	if endpoints := ctx.GlobalString(StorageEndpointsFlag.Name); endpoints != "" {
+		cfg.Endpoints = strings.Split(endpoints, ",")
+	}
+	if watch := ctx.GlobalString(StorageWatchFlag.Name); watch != "" {
+		cfg.Watch = strings.Split(watch, ",")
+	}
 
 	trackers := ctx.GlobalString(StorageTrackerFlag.Name)
 	boostnodes := ctx.GlobalString(StorageBoostNodesFlag.Name)
@@ -1518,12 +2025,27 @@ func SetTorrentFsConfig(ctx *cli.Context, cfg *torrentfs.Config) {
 	log.Debug("FsConfig", "MaxSeedingNum", ctx.GlobalInt(StorageMaxSeedingFlag.Name),
 		"MaxActiveNum", ctx.GlobalInt(StorageMaxActiveFlag.Name))
 	cfg.MaxActiveNum = ctx.GlobalInt(StorageMaxActiveFlag.Name)
+	cfg.MaxStarting = ctx.GlobalInt(StorageMaxStartingFlag.Name)
+	cfg.MaxActiveDownloads = ctx.GlobalInt(StorageMaxActiveDownloadsFlag.Name)
 	cfg.SyncMode = ctx.GlobalString(SyncModeFlag.Name)
 	cfg.DisableDHT = ctx.GlobalBool(StorageDisableDHTFlag.Name)
+	cfg.DisableIPv4 = ctx.GlobalBool(StorageDisableIPv4Flag.Name)
+	cfg.DisableIPv6 = ctx.GlobalBool(StorageDisableIPv6Flag.Name)
 	//cfg.DisableTCP = ctx.GlobalBool(StorageDisableTCPFlag.Name)
 	cfg.FullSeed = ctx.GlobalBool(StorageFullFlag.Name)
 	cfg.Boost = ctx.GlobalBool(StorageBoostFlag.Name)
 	cfg.DataDir = MakeStorageDir(ctx)
+	cfg.Quota = ctx.GlobalUint64(StorageQuotaFlag.Name) * 1024 * 1024
+	cfg.MinFreeSpace = ctx.GlobalUint64(StorageMinFreeFlag.Name) * 1024 * 1024
+	cfg.HealthAddr = ctx.GlobalString(StorageHealthAddrFlag.Name)
+	cfg.ControlAddr = ctx.GlobalString(StorageControlAddrFlag.Name)
+	cfg.PublishDir = ctx.GlobalString(StoragePublishDirFlag.Name)
+	cfg.TraceEndpoint = ctx.GlobalString(StorageTraceEndpointFlag.Name)
+	cfg.Confirmations = ctx.GlobalUint64(StorageConfirmationsFlag.Name)
+	cfg.UploadRate = ctx.GlobalInt(StorageUploadRateFlag.Name)
+	cfg.FairUpload = ctx.GlobalBool(StorageFairUploadFlag.Name)
+	cfg.RecentWeight = ctx.GlobalInt(StorageRecentWeightFlag.Name)
+	cfg.BandwidthShare = ctx.GlobalInt(StorageBandwidthShareFlag.Name)
 }
 
 // RegisterCortexService adds an Cortex client to the stack.
@@ -1547,10 +2069,28 @@ func RegisterShhService(stack *node.Node, cfg *whisper.Config) {
 	}
 }
 
+// storageDirFile records the storage directory in the instance directory.
+const storageDirFile = "storagedir"
+
 // RegisterStorageService adds a torrent file system to the stack.
 func RegisterStorageService(stack *node.Node, cfg *torrentfs.Config, commit string) {
+	if err := cfg.Validate(); err != nil {
+		Fatalf("%v", err)
+	}
 	if err := stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
-		return torrentfs.New(cfg, commit, true, false)
+		// The storage directory of the last run is remembered, so the
+		// storage is moved over when it changes.
+		marker := ctx.ResolvePath(storageDirFile)
+		if prev, err := ioutil.ReadFile(marker); err == nil && cfg.PreviousDataDir == "" {
+			cfg.PreviousDataDir = strings.TrimSpace(string(prev))
+		}
+		fs, err := torrentfs.New(cfg, commit, true, false)
+		if err == nil && marker != "" {
+			if dir, err := filepath.Abs(cfg.DataDir); err == nil {
+				ioutil.WriteFile(marker, []byte(dir+"\n"), 0644)
+			}
+		}
+		return fs, err
 	}); err != nil {
 		Fatalf("Failed to register the storage service: %v", err)
 	}
@@ -290,6 +290,11 @@ func (s *StateDB) TxIndex() int {
 	return s.txIndex
 }
 
+// TxHash returns the current transaction hash set by Prepare.
+func (s *StateDB) TxHash() common.Hash {
+	return s.thash
+}
+
 // BlockHash returns the current block hash set by Prepare.
 func (s *StateDB) BlockHash() common.Hash {
 	return s.bhash
@@ -338,7 +338,7 @@ func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, quotaUsed
 
 		//log.Warn("VM returned with error", "err", vmerr, "number", cvm.BlockNumber, "from", msg.From().Hex())
 
-		if vmerr == vm.ErrRuntime {
+		if errors.Is(vmerr, vm.ErrRuntime) {
 			return nil, 0, big0, false, vmerr
 		}
 
@@ -0,0 +1,195 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"sync"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/asm"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/core/vm"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/event"
+	"github.com/CortexFoundation/torrentfs"
+	fstypes "github.com/CortexFoundation/torrentfs/types"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	// modelRefsCacheSize is the number of contract codes whose model
+	// references are remembered.
+	modelRefsCacheSize = 1024
+
+	// modelCompletedChanSize is the size of the channel listening to
+	// completed torrents.
+	modelCompletedChanSize = 16
+)
+
+var emptyCodeHash = crypto.Keccak256Hash(nil)
+
+// ModelOracle tells whether the models of inference transactions are ready
+// to be used by the local inference engine.
+type ModelOracle interface {
+	// ModelAvailable reports whether a model is fully downloaded and expected
+	// to load. It must answer quickly, without reading the model.
+	ModelAvailable(modelInfoHash string, rawSize uint64) bool
+}
+
+// completionSource is implemented by oracles notifying about completed
+// torrents, after which held back transactions are checked again.
+type completionSource interface {
+	SubscribeCompleted(ch chan<- torrentfs.TorrentCompleted) event.Subscription
+}
+
+// modelRefs returns the candidate model addresses of a contract: the
+// addresses pushed by contract code using an inference opcode. Models are
+// picked at runtime, so these are only a hint, but contracts almost always
+// embed the address of the model they run. The heuristic is best effort:
+// contracts loading model addresses from storage or calldata have no
+// PUSH20 literal for them, and their transactions are never held back.
+func modelRefs(code []byte) []common.Address {
+	var (
+		refs  []common.Address
+		infer bool
+	)
+	for it := asm.NewInstructionIterator(code); it.Next(); {
+		switch op := it.Op(); {
+		case op == vm.PUSH20:
+			refs = append(refs, common.BytesToAddress(it.Arg()))
+		case op.IsInfer():
+			infer = true
+		}
+	}
+	if !infer {
+		return nil
+	}
+	return refs
+}
+
+// modelTracker resolves the models a transaction is expected to run and
+// caches whether they are ready. The checks run on a copy of the pool state
+// taken once per head, and are kept until the next head, except those of held
+// back transactions which are dropped whenever a model completes.
+type modelTracker struct {
+	refs *lru.Cache // code hash -> []common.Address
+
+	lock   sync.Mutex
+	base   *state.StateDB       // pool state the copy was taken from
+	state  *state.StateDB       // copy of base, read outside the pool lock
+	checks map[common.Hash]bool // readiness by transaction hash
+}
+
+func newModelTracker() *modelTracker {
+	refs, _ := lru.New(modelRefsCacheSize)
+	return &modelTracker{refs: refs, checks: make(map[common.Hash]bool)}
+}
+
+// snapshot makes the checks run against the given pool state, forgetting
+// the results on an older one. It must be called with the pool lock held.
+func (t *modelTracker) snapshot(statedb *state.StateDB) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.base == statedb {
+		return
+	}
+	t.base, t.state = statedb, statedb.Copy()
+	t.checks = make(map[common.Hash]bool)
+}
+
+// release forgets the held back transactions, so they are checked again.
+func (t *modelTracker) release() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for hash, ready := range t.checks {
+		if !ready {
+			delete(t.checks, hash)
+		}
+	}
+}
+
+// models returns the addresses of the models referenced by the contract tx
+// calls along with their metas, as registered in the given state.
+func (t *modelTracker) models(statedb *state.StateDB, tx *types.Transaction) ([]common.Address, []*fstypes.ModelMeta) {
+	to := tx.To()
+	if to == nil {
+		return nil, nil
+	}
+	hash := statedb.GetCodeHash(*to)
+	if hash == (common.Hash{}) || hash == emptyCodeHash {
+		return nil, nil
+	}
+	var refs []common.Address
+	if cached, ok := t.refs.Get(hash); ok {
+		refs = cached.([]common.Address)
+	} else {
+		refs = modelRefs(statedb.GetCode(*to))
+		t.refs.Add(hash, refs)
+	}
+	var (
+		addrs []common.Address
+		metas []*fstypes.ModelMeta
+	)
+	for _, addr := range refs {
+		if meta, _, err := fstypes.ParseModelMeta(statedb.GetCode(addr), fstypes.MaxMetaVersion); err == nil {
+			addrs = append(addrs, addr)
+			metas = append(metas, meta)
+		}
+	}
+	return addrs, metas
+}
+
+// ready reports whether every model tx is expected to run is available. A
+// model still uploading is never available.
+func (t *modelTracker) ready(oracle ModelOracle, tx *types.Transaction) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	hash := tx.Hash()
+	if ready, ok := t.checks[hash]; ok {
+		return ready
+	}
+	ready := true
+	addrs, metas := t.models(t.state, tx)
+	for i, meta := range metas {
+		if t.state.Uploading(addrs[i]) || !oracle.ModelAvailable(meta.Hash.Hex(), meta.RawSize) {
+			ready = false
+			break
+		}
+	}
+	t.checks[hash] = ready
+	return ready
+}
+
+// modelLoop releases the held back transactions whenever a model completes.
+func (pool *TxPool) modelLoop(ch <-chan torrentfs.TorrentCompleted, sub event.Subscription) {
+	defer pool.wg.Done()
+
+	for {
+		select {
+		case ev := <-ch:
+			if ev.IsModel() {
+				pool.models.release()
+			}
+		case <-sub.Err():
+			return
+		}
+	}
+}
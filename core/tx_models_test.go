@@ -0,0 +1,132 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/core/vm"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/event"
+	"github.com/CortexFoundation/torrentfs"
+	fstypes "github.com/CortexFoundation/torrentfs/types"
+)
+
+type testModelOracle struct {
+	lock      sync.Mutex
+	available map[string]bool
+	completed event.Feed
+}
+
+func (o *testModelOracle) ModelAvailable(modelInfoHash string, rawSize uint64) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.available[modelInfoHash]
+}
+
+func (o *testModelOracle) SubscribeCompleted(ch chan<- torrentfs.TorrentCompleted) event.Subscription {
+	return o.completed.Subscribe(ch)
+}
+
+// complete makes a model available and reports its torrent completed.
+func (o *testModelOracle) complete(modelInfoHash string) {
+	o.lock.Lock()
+	o.available[modelInfoHash] = true
+	o.lock.Unlock()
+	o.completed.Send(torrentfs.TorrentCompleted{InfoHash: modelInfoHash, Kind: "model"})
+}
+
+// inferCode returns contract code running INFERARRAY on the given model.
+func inferCode(model common.Address, infer bool) []byte {
+	code := append([]byte{byte(vm.PUSH20)}, model.Bytes()...)
+	if infer {
+		code = append(code, byte(vm.INFERARRAY))
+	}
+	return append(code, byte(vm.STOP))
+}
+
+func TestModelRefs(t *testing.T) {
+	model := common.HexToAddress("0x1")
+	if refs := modelRefs(inferCode(model, true)); len(refs) != 1 || refs[0] != model {
+		t.Fatalf("refs %v, want [%v]", refs, model)
+	}
+	if refs := modelRefs(inferCode(model, false)); len(refs) != 0 {
+		t.Fatalf("contract without inference references models %v", refs)
+	}
+}
+
+func TestTransactionPendingReady(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	pool.currentState.AddBalance(account, big.NewInt(1000000000))
+
+	var (
+		modelAddr = common.HexToAddress("0x1000")
+		contract  = common.HexToAddress("0x2000")
+		meta      = &fstypes.ModelMeta{Hash: common.HexToAddress("0xabcd"), RawSize: 100}
+	)
+	enc, err := meta.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.currentState.SetCode(modelAddr, append([]byte{0, 1}, enc...))
+	pool.currentState.SetCode(contract, inferCode(modelAddr, true))
+
+	plain := transaction(0, 100000, key)
+	infer, _ := types.SignTx(types.NewTransaction(1, contract, big.NewInt(0), 100000, big.NewInt(1), nil), types.HomesteadSigner{}, key)
+	after := transaction(2, 100000, key)
+	for _, tx := range []*types.Transaction{plain, infer, after} {
+		if err := pool.addRemoteSync(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oracle := &testModelOracle{available: make(map[string]bool)}
+	pool.SetModelOracle(oracle)
+	pending, _ := pool.PendingReady()
+	if txs := pending[account]; len(txs) != 1 || txs[0].Hash() != plain.Hash() {
+		t.Fatalf("pending with model unavailable: %d txs, want 1", len(txs))
+	}
+	// Held back transactions are only checked again once a model completes
+	oracle.lock.Lock()
+	oracle.available[meta.Hash.Hex()] = true
+	oracle.lock.Unlock()
+	if pending, _ = pool.PendingReady(); len(pending[account]) != 1 {
+		t.Fatalf("pending rechecked without completion: %d txs, want 1", len(pending[account]))
+	}
+	oracle.complete(meta.Hash.Hex())
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if pending, _ = pool.PendingReady(); len(pending[account]) == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pending with model completed: %d txs, want 3", len(pending[account]))
+		}
+	}
+	if all, _ := pool.Pending(); len(all[account]) != 3 {
+		t.Fatalf("pending without availability check: %d txs, want 3", len(all[account]))
+	}
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/log"
 	"github.com/CortexFoundation/CortexTheseus/metrics"
 	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/torrentfs"
 )
 
 const (
@@ -103,6 +104,7 @@ var (
 	pendingReplaceMeter   = metrics.NewRegisteredMeter("txpool/pending/replace", nil)
 	pendingRateLimitMeter = metrics.NewRegisteredMeter("txpool/pending/ratelimit", nil) // Dropped due to rate limiting
 	pendingNofundsMeter   = metrics.NewRegisteredMeter("txpool/pending/nofunds", nil)   // Dropped due to out-of-funds
+	pendingNoModelMeter   = metrics.NewRegisteredMeter("txpool/pending/nomodel", nil)   // Held back until the model is available
 
 	// Metrics for the queued pool
 	queuedDiscardMeter   = metrics.NewRegisteredMeter("txpool/queued/discard", nil)
@@ -244,6 +246,10 @@ type TxPool struct {
 	locals  *accountSet // Set of local transaction to exempt from eviction rules
 	journal *txJournal  // Journal of local transaction to back up to disk
 
+	oracle    ModelOracle        // Availability of inference models, nil if not checked
+	oracleSub event.Subscription // Completed torrents reported by the oracle
+	models    *modelTracker      // Models referenced by called contracts
+
 	pending map[common.Address]*txList   // All currently processable transactions
 	queue   map[common.Address]*txList   // Queued but non-processable transactions
 	beats   map[common.Address]time.Time // Last heartbeat from each known account
@@ -287,6 +293,7 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 		reorgDoneCh:     make(chan chan struct{}),
 		reorgShutdownCh: make(chan struct{}),
 		gasPrice:        new(big.Int).SetUint64(config.PriceLimit),
+		models:          newModelTracker(),
 	}
 	pool.locals = newAccountSet(pool.signer)
 	for _, addr := range config.Locals {
@@ -402,6 +409,12 @@ func (pool *TxPool) Stop() {
 
 	// Unsubscribe subscriptions registered from blockchain
 	pool.chainHeadSub.Unsubscribe()
+
+	pool.mu.Lock()
+	if pool.oracleSub != nil {
+		pool.oracleSub.Unsubscribe()
+	}
+	pool.mu.Unlock()
 	pool.wg.Wait()
 
 	if pool.journal != nil {
@@ -500,6 +513,66 @@ func (pool *TxPool) Pending() (map[common.Address]types.Transactions, error) {
 	return pending, nil
 }
 
+// SetModelOracle makes PendingReady hold back inference transactions whose
+// models the oracle doesn't report as available. Oracles reporting completed
+// torrents get held back transactions checked again as soon as a model
+// completes, instead of at the next head.
+func (pool *TxPool) SetModelOracle(oracle ModelOracle) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.oracleSub != nil {
+		pool.oracleSub.Unsubscribe()
+		pool.oracleSub = nil
+	}
+	pool.oracle = oracle
+	if src, ok := oracle.(completionSource); ok {
+		ch := make(chan torrentfs.TorrentCompleted, modelCompletedChanSize)
+		pool.oracleSub = src.SubscribeCompleted(ch)
+		pool.wg.Add(1)
+		go pool.modelLoop(ch, pool.oracleSub)
+	}
+}
+
+// PendingReady is Pending without the transactions expected to run a model
+// the local inference engine can't use yet. An account's transactions are cut
+// at the first one held back, keeping the nonces gapless. Executing them
+// would only fail the block under construction, so they are left in the pool
+// until the model has been downloaded.
+//
+// The oracle is queried without holding the pool lock, and only once per
+// transaction and head, see modelTracker.
+func (pool *TxPool) PendingReady() (map[common.Address]types.Transactions, error) {
+	pool.mu.Lock()
+	pending := make(map[common.Address]types.Transactions)
+	for addr, list := range pool.pending {
+		pending[addr] = list.Flatten()
+	}
+	oracle := pool.oracle
+	if oracle == nil {
+		pool.mu.Unlock()
+		return pending, nil
+	}
+	pool.models.snapshot(pool.currentState)
+	pool.mu.Unlock()
+
+	for addr, txs := range pending {
+		for i, tx := range txs {
+			if !pool.models.ready(oracle, tx) {
+				pendingNoModelMeter.Mark(int64(len(txs) - i))
+				txs = txs[:i]
+				break
+			}
+		}
+		if len(txs) > 0 {
+			pending[addr] = txs
+		} else {
+			delete(pending, addr)
+		}
+	}
+	return pending, nil
+}
+
 // Locals retrieves the accounts currently considered local by the pool.
 func (pool *TxPool) Locals() []common.Address {
 	pool.mu.Lock()
@@ -22,6 +22,7 @@ import (
 
 	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
 	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/params"
 )
 
 type bytesBacked interface {
@@ -134,3 +135,24 @@ func BloomLookup(bin Bloom, topic bytesBacked) bool {
 
 	return bloom.And(bloom, cmp).Cmp(cmp) == 0
 }
+
+// StorageMetaKey marks a storage bloom of a block carrying at least one
+// transaction with model or input meta.
+var StorageMetaKey = []byte("storage-meta")
+
+// StorageBloom creates a bloom filter over the transactions of a block that
+// the storage layer has to look at: the meta key for every model or input
+// meta payload and the recipient of every transaction that may be an upload.
+// A block whose storage bloom matches neither can be skipped without parsing.
+func StorageBloom(txs Transactions) Bloom {
+	bin := new(big.Int)
+	for _, tx := range txs {
+		if data := tx.Data(); len(data) >= 2 && data[0] == 0 && (data[1] == 1 || data[1] == 2) {
+			bin.Or(bin, bloom9(StorageMetaKey))
+		}
+		if to := tx.To(); to != nil && tx.Value().Sign() == 0 && tx.Gas() >= params.UploadGas {
+			bin.Or(bin, bloom9(to.Bytes()))
+		}
+	}
+	return BytesToBloom(bin.Bytes())
+}
@@ -19,6 +19,9 @@ package types
 import (
 	"math/big"
 	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/params"
 )
 
 func TestBloom(t *testing.T) {
@@ -50,6 +53,33 @@ func TestBloom(t *testing.T) {
 	}
 }
 
+func TestStorageBloom(t *testing.T) {
+	var (
+		upload   = common.HexToAddress("0x1000000000000000000000000000000000000001")
+		transfer = common.HexToAddress("0x2000000000000000000000000000000000000002")
+	)
+	bloom := StorageBloom(Transactions{
+		NewTransaction(0, transfer, big.NewInt(1), params.UploadGas, big.NewInt(1), nil),
+		NewTransaction(1, upload, big.NewInt(0), params.UploadGas, big.NewInt(1), nil),
+	})
+	if !BloomLookup(bloom, upload) {
+		t.Error("expected upload recipient to test true")
+	}
+	if BloomLookup(bloom, transfer) {
+		t.Error("did not expect transfer recipient to test true")
+	}
+	if bloom.TestBytes(StorageMetaKey) {
+		t.Error("did not expect meta key to test true")
+	}
+
+	bloom = StorageBloom(Transactions{
+		NewContractCreation(2, big.NewInt(0), 100000, big.NewInt(1), []byte{0, 1, 0xc0}),
+	})
+	if !bloom.TestBytes(StorageMetaKey) {
+		t.Error("expected meta key to test true")
+	}
+}
+
 /*
 import (
 	"testing"
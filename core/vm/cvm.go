@@ -570,6 +570,15 @@ func (cvm *CVM) DataSync(meta common.Address, dir string, errCh chan error) {
 	//return
 }*/
 
+// modelKey returns the key the inference engine runs a model by, which only
+// accepts models published in onnx format from the ONNX fork on.
+func (cvm *CVM) modelKey(modelInfoHash string) string {
+	if cvm.chainConfig.IsONNX(cvm.BlockNumber) {
+		return synapse.ONNXModel(modelInfoHash)
+	}
+	return modelInfoHash
+}
+
 // infer function that returns an int64 as output, can be used a categorical output
 func (cvm *CVM) Infer(modelInfoHash, inputInfoHash string, modelRawSize, inputRawSize uint64) ([]byte, error) {
 	//log.Info("Inference Information", "Model Hash", modelInfoHash, "Input Hash", inputInfoHash)
@@ -592,8 +601,11 @@ func (cvm *CVM) Infer(modelInfoHash, inputInfoHash string, modelRawSize, inputRa
 
 	start := mclock.Now()
 
-	inferRes, errRes = synapse.Engine().InferByInfoHash(modelInfoHash, inputInfoHash)
+	inferRes, errRes = synapse.Engine().InferByInfoHash(cvm.modelKey(modelInfoHash), inputInfoHash)
 	elapsed := time.Duration(mclock.Now()) - time.Duration(start)
+	synapse.Engine().Audit(cvm.BlockNumber.Uint64(), cvm.StateDB.TxHash(), modelInfoHash, inputInfoHash, inferRes, errRes, elapsed)
+	synapse.Engine().Reference(cvm.BlockNumber.Uint64(), cvm.StateDB.TxHash(), modelInfoHash)
+	synapse.Engine().Dump(cvm.BlockNumber.Uint64(), cvm.StateDB.TxHash(), modelInfoHash, inputInfoHash, nil, inferRes, errRes)
 
 	if errRes == nil {
 		log.Debug("[hash ] succeed", "label", inferRes, "model", modelInfoHash, "input", inputInfoHash, "number", cvm.BlockNumber, "elapsed", common.PrettyDuration(elapsed))
@@ -627,8 +639,11 @@ func (cvm *CVM) InferArray(modelInfoHash string, inputArray []byte, modelRawSize
 
 	start := mclock.Now()
 
-	inferRes, errRes = synapse.Engine().InferByInputContent(modelInfoHash, inputArray)
+	inferRes, errRes = synapse.Engine().InferByInputContent(cvm.modelKey(modelInfoHash), inputArray)
 	elapsed := time.Duration(mclock.Now()) - time.Duration(start)
+	synapse.Engine().Audit(cvm.BlockNumber.Uint64(), cvm.StateDB.TxHash(), modelInfoHash, synapse.RLPHashString(inputArray), inferRes, errRes, elapsed)
+	synapse.Engine().Reference(cvm.BlockNumber.Uint64(), cvm.StateDB.TxHash(), modelInfoHash)
+	synapse.Engine().Dump(cvm.BlockNumber.Uint64(), cvm.StateDB.TxHash(), modelInfoHash, "", inputArray, inferRes, errRes)
 
 	if errRes == nil {
 		log.Debug("[array] succeed", "label", inferRes, "model", modelInfoHash, "array", inputArray, "number", cvm.BlockNumber, "elapsed", common.PrettyDuration(elapsed))
@@ -655,7 +670,7 @@ func (cvm *CVM) OpsInfer(addr common.Address) (opsRes uint64, errRes error) {
 
 	start := mclock.Now()
 
-	opsRes, errRes = synapse.Engine().GetGasByInfoHash(modelMeta.Hash.Hex())
+	opsRes, errRes = synapse.Engine().GetGasByInfoHash(cvm.modelKey(modelMeta.Hash.Hex()))
 
 	elapsed := time.Duration(mclock.Now()) - time.Duration(start)
 
@@ -687,11 +702,20 @@ func (cvm *CVM) OpsInfer(addr common.Address) (opsRes uint64, errRes error) {
 	return common.EmptyAddress, errors.New("quota limit reached")
 }*/
 
+// metaVersion returns the newest version of the upload metas the CVM
+// accepts, versioned metas are only decoded from the MetaVersion fork on.
+func (cvm *CVM) metaVersion() uint8 {
+	if cvm.chainConfig.IsMetaVersion(cvm.BlockNumber) {
+		return torrentfs.MaxMetaVersion
+	}
+	return torrentfs.MetaVersionLegacy
+}
+
 func (cvm *CVM) GetModelMeta(addr common.Address) (meta *torrentfs.ModelMeta, err error) {
 	log.Trace(fmt.Sprintf("GeteModelMeta = %v", addr))
 	modelMetaRaw := cvm.StateDB.GetCode(addr)
 	log.Trace(fmt.Sprintf("modelMetaRaw: %v", modelMetaRaw))
-	if modelMeta, err := torrentfs.ParseModelMeta(modelMetaRaw); err != nil {
+	if modelMeta, _, err := torrentfs.ParseModelMeta(modelMetaRaw, cvm.metaVersion()); err != nil {
 		return &torrentfs.ModelMeta{}, err
 	} else {
 		return modelMeta, nil
@@ -702,7 +726,7 @@ func (cvm *CVM) GetInputMeta(addr common.Address) (meta *torrentfs.InputMeta, er
 	inputMetaRaw := cvm.StateDB.GetCode(addr)
 	log.Trace(fmt.Sprintf("inputMetaRaw: %v", inputMetaRaw))
 	// fmt.Println("inputMetaRaw: %v", inputMetaRaw)
-	if inputMeta, err := torrentfs.ParseInputMeta(inputMetaRaw); err != nil {
+	if inputMeta, _, err := torrentfs.ParseInputMeta(inputMetaRaw, cvm.metaVersion()); err != nil {
 		return &torrentfs.InputMeta{}, err
 	} else {
 		return inputMeta, nil
@@ -79,6 +79,9 @@ type StateDB interface {
 
 	ForEachStorage(common.Address, func(common.Hash, common.Hash) bool) error
 	//GetCurrentLogs() []*types.Log
+
+	// TxHash returns the hash of the transaction being executed.
+	TxHash() common.Hash
 }
 
 // CallContext provides a basic interface for the CVM calling conventions. The CVM
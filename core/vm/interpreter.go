@@ -17,6 +17,7 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 	"hash"
 	"math/big"
@@ -248,8 +249,7 @@ func (in *CVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			return nil, nil
 		}
 
-		var modelMeta torrentfs.ModelMeta
-		if err := modelMeta.DecodeRLP(contract.Code); err != nil {
+		if modelMeta, version, err := torrentfs.ParseModelMeta(contract.Code, in.cvm.metaVersion()); err != nil {
 			log.Error("Failed decode model meta", "code", contract.Code, "err", err)
 			return nil, err
 		} else {
@@ -292,10 +292,10 @@ func (in *CVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 
 				in.cvm.StateDB.SetNum(contract.Address(), in.cvm.BlockNumber)
 				modelMeta.SetBlockNum(*in.cvm.BlockNumber)
-				if tmpCode, err := modelMeta.ToBytes(); err != nil {
+				if tmpCode, err := torrentfs.EncodeMeta(torrentfs.ModelMetaCode, version, modelMeta); err != nil {
 					return nil, err
 				} else {
-					contract.Code = append([]byte{0, 1}, tmpCode...)
+					contract.Code = tmpCode
 				}
 				log.Debug("Model created", "size", modelMeta.RawSize, "hash", modelMeta.Hash.Hex(), "author", modelMeta.AuthorAddress.Hex(), "gas", modelMeta.Gas, "birth", modelMeta.BlockNum.Uint64())
 			} else {
@@ -316,8 +316,7 @@ func (in *CVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			return nil, nil
 		}
 
-		var inputMeta torrentfs.InputMeta
-		if err := inputMeta.DecodeRLP(contract.Code); err != nil {
+		if inputMeta, version, err := torrentfs.ParseInputMeta(contract.Code, in.cvm.metaVersion()); err != nil {
 			log.Error("Failed decode input meta", "code", contract.Code, "err", err)
 			return nil, err
 		} else {
@@ -332,10 +331,10 @@ func (in *CVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 
 				inputMeta.SetBlockNum(*in.cvm.BlockNumber)
 				in.cvm.StateDB.SetNum(contract.Address(), in.cvm.BlockNumber)
-				if tmpCode, err := inputMeta.ToBytes(); err != nil {
+				if tmpCode, err := torrentfs.EncodeMeta(torrentfs.InputMetaCode, version, inputMeta); err != nil {
 					return nil, err
 				} else {
-					contract.Code = append([]byte{0, 2}, tmpCode...)
+					contract.Code = tmpCode
 				}
 				//log.Info("Input meta created", "size", inputMeta.RawSize, "author", inputMeta.AuthorAddress)
 			} else {
@@ -434,7 +433,7 @@ func (in *CVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		}
 
 		// gasCost will check model's metainfo before checking available gas
-		if err == ErrRuntime {
+		if errors.Is(err, ErrRuntime) {
 			return nil, err
 		}
 
@@ -158,14 +158,47 @@ func New(ctx *node.ServiceContext, config *Config) (*Cortex, error) {
 		}
 	}
 
+	if config.InferCacheJournal != "" {
+		config.InferCacheJournal = ctx.ResolvePath(config.InferCacheJournal)
+	}
+	if config.InferAuditLog != "" {
+		config.InferAuditLog = ctx.ResolvePath(config.InferAuditLog)
+	}
+	if config.InferDumpDir != "" {
+		config.InferDumpDir = ctx.ResolvePath(config.InferDumpDir)
+	}
+	// The storage runs as a service of its own, registered ahead of this one,
+	// so it is started before and stopped after the inference engine.
+	var (
+		storagefs torrentfs.CortexStorage
+		tfs       *torrentfs.TorrentFS
+	)
+	if err := ctx.Service(&tfs); err == nil {
+		storagefs = tfs
+	}
 	ctxc.synapse = synapse.New(&synapse.Config{
-		DeviceType:     config.InferDeviceType,
-		DeviceId:       config.InferDeviceId,
-		MaxMemoryUsage: config.InferMemoryUsage,
-		IsRemoteInfer:  config.InferURI != "",
-		InferURI:       config.InferURI,
-		IsNotCache:     false,
-		Storagefs:      torrentfs.GetStorage(), //torrentfs.Torrentfs_handle,
+		DeviceType:         config.InferDeviceType,
+		DeviceId:           config.InferDeviceId,
+		DeviceIds:          config.InferDeviceIds,
+		DeviceQueue:        config.InferDeviceQueue,
+		Deterministic:      config.InferDeterministic,
+		Workers:            config.InferWorkers,
+		InferTimeout:       config.InferTimeout,
+		AuditLog:           config.InferAuditLog,
+		DumpDir:            config.InferDumpDir,
+		Backends:           config.InferBackends,
+		Sandbox:            config.InferSandbox,
+		SandboxMemory:      config.InferSandboxMemory,
+		SandboxOutput:      config.InferSandboxOutput,
+		SandboxCgroup:      config.InferSandboxCgroup,
+		MaxMemoryUsage:     config.InferMemoryUsage,
+		IsRemoteInfer:      config.InferURI != "",
+		InferURI:           config.InferURI,
+		IsNotCache:         false,
+		ResultCacheSize:    config.InferCacheSize,
+		ResultCacheJournal: config.InferCacheJournal,
+		WarmLoad:           config.InferWarmLoad,
+		Storagefs:          storagefs,
 	})
 
 	var (
@@ -201,6 +234,9 @@ func New(ctx *node.ServiceContext, config *Config) (*Cortex, error) {
 		config.TxPool.Journal = ctx.ResolvePath(config.TxPool.Journal)
 	}
 	ctxc.txPool = core.NewTxPool(config.TxPool, ctxc.chainConfig, ctxc.blockchain)
+	if ctxc.synapse != nil {
+		ctxc.txPool.SetModelOracle(ctxc.synapse)
+	}
 
 	cacheLimit := cacheConfig.TrieCleanLimit + cacheConfig.TrieDirtyLimit + cacheConfig.SnapshotLimit
 
@@ -284,6 +320,18 @@ func (s *Cortex) APIs() []rpc.API {
 	// Append any APIs exposed explicitly by the consensus engine
 	apis = append(apis, s.engine.APIs(s.BlockChain())...)
 
+	if s.synapse != nil {
+		onnx := func() bool {
+			return s.blockchain.Config().IsONNX(s.blockchain.CurrentBlock().Number())
+		}
+		apis = append(apis, rpc.API{
+			Namespace: "synapse",
+			Version:   "1.0",
+			Service:   synapse.NewPublicSynapseAPI(s.synapse, onnx),
+			Public:    true,
+		})
+	}
+
 	// Append all the local APIs and return
 	return append(apis, []rpc.API{
 		{
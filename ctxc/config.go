@@ -95,10 +95,25 @@ type Config struct {
 	Miner miner.Config
 
 	// Mining-related options
-	Coinbase         common.Address `toml:",omitempty"`
-	InferDeviceType  string
-	InferDeviceId    int
-	InferMemoryUsage int64
+	Coinbase           common.Address `toml:",omitempty"`
+	InferDeviceType    string
+	InferDeviceId      int
+	InferDeviceIds     []int
+	InferDeviceQueue   int
+	InferDeterministic bool
+	InferWorkers       int
+	InferTimeout       time.Duration
+	InferAuditLog      string
+	InferDumpDir       string
+	InferBackends      []string
+	InferSandbox       bool
+	InferSandboxMemory int64
+	InferSandboxOutput int
+	InferSandboxCgroup string
+	InferMemoryUsage   int64
+	InferCacheSize     int
+	InferCacheJournal  string
+	InferWarmLoad      bool
 
 	Cuckoo cuckoo.Config
 
@@ -304,7 +304,10 @@ func (d *Downloader) Synchronise(id string, head common.Hash, td *big.Int, mode
 	err := d.synchronise(id, head, td, mode)
 
 	switch err {
-	case nil, errBusy, errCanceled, vm.ErrRuntime:
+	case nil, errBusy, errCanceled:
+		return err
+	}
+	if errors.Is(err, vm.ErrRuntime) {
 		return err
 	}
 
@@ -38,7 +38,22 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		Coinbase                common.Address `toml:",omitempty"`
 		InferDeviceType         string
 		InferDeviceId           int
+		InferDeviceIds          []int
+		InferDeviceQueue        int
+		InferDeterministic      bool
+		InferWorkers            int
+		InferTimeout            time.Duration
+		InferAuditLog           string
+		InferDumpDir            string
+		InferBackends           []string
+		InferSandbox            bool
+		InferSandboxMemory      int64
+		InferSandboxOutput      int
+		InferSandboxCgroup      string
 		InferMemoryUsage        int64
+		InferCacheSize          int
+		InferCacheJournal       string
+		InferWarmLoad           bool
 		Cuckoo                  cuckoo.Config
 		TxPool                  core.TxPoolConfig
 		GPO                     gasprice.Config
@@ -74,7 +89,22 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.Coinbase = c.Coinbase
 	enc.InferDeviceType = c.InferDeviceType
 	enc.InferDeviceId = c.InferDeviceId
+	enc.InferDeviceIds = c.InferDeviceIds
+	enc.InferDeviceQueue = c.InferDeviceQueue
+	enc.InferDeterministic = c.InferDeterministic
+	enc.InferWorkers = c.InferWorkers
+	enc.InferTimeout = c.InferTimeout
+	enc.InferAuditLog = c.InferAuditLog
+	enc.InferDumpDir = c.InferDumpDir
+	enc.InferBackends = c.InferBackends
+	enc.InferSandbox = c.InferSandbox
+	enc.InferSandboxMemory = c.InferSandboxMemory
+	enc.InferSandboxOutput = c.InferSandboxOutput
+	enc.InferSandboxCgroup = c.InferSandboxCgroup
 	enc.InferMemoryUsage = c.InferMemoryUsage
+	enc.InferCacheSize = c.InferCacheSize
+	enc.InferCacheJournal = c.InferCacheJournal
+	enc.InferWarmLoad = c.InferWarmLoad
 	enc.Cuckoo = c.Cuckoo
 	enc.TxPool = c.TxPool
 	enc.GPO = c.GPO
@@ -114,7 +144,22 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		Coinbase                *common.Address `toml:",omitempty"`
 		InferDeviceType         *string
 		InferDeviceId           *int
+		InferDeviceIds          *[]int
+		InferDeviceQueue        *int
+		InferDeterministic      *bool
+		InferWorkers            *int
+		InferTimeout            *time.Duration
+		InferAuditLog           *string
+		InferDumpDir            *string
+		InferBackends           []string
+		InferSandbox            *bool
+		InferSandboxMemory      *int64
+		InferSandboxOutput      *int
+		InferSandboxCgroup      *string
 		InferMemoryUsage        *int64
+		InferCacheSize          *int
+		InferCacheJournal       *string
+		InferWarmLoad           *bool
 		Cuckoo                  *cuckoo.Config
 		TxPool                  *core.TxPoolConfig
 		GPO                     *gasprice.Config
@@ -193,9 +238,54 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.InferDeviceId != nil {
 		c.InferDeviceId = *dec.InferDeviceId
 	}
+	if dec.InferDeviceIds != nil {
+		c.InferDeviceIds = *dec.InferDeviceIds
+	}
+	if dec.InferDeviceQueue != nil {
+		c.InferDeviceQueue = *dec.InferDeviceQueue
+	}
+	if dec.InferDeterministic != nil {
+		c.InferDeterministic = *dec.InferDeterministic
+	}
+	if dec.InferWorkers != nil {
+		c.InferWorkers = *dec.InferWorkers
+	}
+	if dec.InferTimeout != nil {
+		c.InferTimeout = *dec.InferTimeout
+	}
+	if dec.InferAuditLog != nil {
+		c.InferAuditLog = *dec.InferAuditLog
+	}
+	if dec.InferDumpDir != nil {
+		c.InferDumpDir = *dec.InferDumpDir
+	}
+	if dec.InferBackends != nil {
+		c.InferBackends = dec.InferBackends
+	}
+	if dec.InferSandbox != nil {
+		c.InferSandbox = *dec.InferSandbox
+	}
+	if dec.InferSandboxMemory != nil {
+		c.InferSandboxMemory = *dec.InferSandboxMemory
+	}
+	if dec.InferSandboxOutput != nil {
+		c.InferSandboxOutput = *dec.InferSandboxOutput
+	}
+	if dec.InferSandboxCgroup != nil {
+		c.InferSandboxCgroup = *dec.InferSandboxCgroup
+	}
 	if dec.InferMemoryUsage != nil {
 		c.InferMemoryUsage = *dec.InferMemoryUsage
 	}
+	if dec.InferCacheSize != nil {
+		c.InferCacheSize = *dec.InferCacheSize
+	}
+	if dec.InferCacheJournal != nil {
+		c.InferCacheJournal = *dec.InferCacheJournal
+	}
+	if dec.InferWarmLoad != nil {
+		c.InferWarmLoad = *dec.InferWarmLoad
+	}
 	if dec.Cuckoo != nil {
 		c.Cuckoo = *dec.Cuckoo
 	}
@@ -55,6 +55,12 @@ func (m *Model) Size() uint64 {
 func (m *Model) GetInputLength() uint64 {
 	return m.input_size
 }
+func (m *Model) GetInputTypeSize() uint64 {
+	return m.input_byte
+}
+func (m *Model) GetOutputTypeSize() uint64 {
+	return m.output_byte
+}
 
 func (m *Model) Predict(data []byte) ([]byte, int) {
 	var (
@@ -182,21 +182,3 @@ func NewReader(r io.Reader) (*NpyReader, error) {
 
 	return rdr, nil
 }
-
-func DumpToFile(fname string, data []byte) error {
-	w, err := os.Create(fname)
-	if err != nil {
-		return err
-	}
-
-	for _, v := range data {
-		err := binary.Write(w, binary.LittleEndian, v)
-		if err != nil {
-			return err
-		}
-	}
-
-	w.Close()
-
-	return nil
-}
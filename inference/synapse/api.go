@@ -0,0 +1,128 @@
+package synapse
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+)
+
+// PublicSynapseAPI exposes the inference engine over RPC.
+type PublicSynapseAPI struct {
+	s    *Synapse
+	onnx func() bool // whether the ONNX fork is active at the head, may be nil
+}
+
+// NewPublicSynapseAPI creates a new RPC service for the inference engine.
+// The onnx callback tells whether contract calls at the head of the chain
+// infer onnx models.
+func NewPublicSynapseAPI(s *Synapse, onnx func() bool) *PublicSynapseAPI {
+	return &PublicSynapseAPI{s, onnx}
+}
+
+// PreloadStatus is the progress of a model preload.
+type PreloadStatus struct {
+	Hash  string `json:"hash"`
+	Ready bool   `json:"ready"`
+	Done  bool   `json:"done"`
+	Error string `json:"error,omitempty"`
+}
+
+// PreloadModel starts preloading a model, or reports the progress of the
+// preload already running for it.
+func (api *PublicSynapseAPI) PreloadModel(modelHash string) PreloadStatus {
+	f := api.s.PreloadModel(modelHash)
+	status := PreloadStatus{Hash: f.Hash(), Ready: f.Ready(), Done: isDone(f.done)}
+	if status.Done && f.err != nil {
+		status.Error = f.err.Error()
+	}
+	return status
+}
+
+// ModelInfo returns the input and output signature of a loaded model.
+func (api *PublicSynapseAPI) ModelInfo(modelHash string) (*ModelMeta, error) {
+	if len(modelHash) < 2 || !strings.HasPrefix(modelHash, "0x") {
+		return nil, KERNEL_RUNTIME_ERROR
+	}
+	meta, ok := api.s.ModelMeta(strings.ToLower(modelHash[2:]))
+	if !ok {
+		return nil, errors.New("model not loaded")
+	}
+	return meta, nil
+}
+
+// CheckModel validates a model without executing it, downloading it first if
+// needed, so publishers can catch unsupported operators or a model too large
+// for the inference devices.
+func (api *PublicSynapseAPI) CheckModel(ctx context.Context, modelHash string) (*ModelCheck, error) {
+	return api.s.CheckModel(ctx, modelHash)
+}
+
+// CacheStats returns the model cache usage of every inference device.
+func (api *PublicSynapseAPI) CacheStats() []DeviceCacheStats {
+	return api.s.CacheStats()
+}
+
+// Infer runs a model on the given input the same way a contract call would,
+// so results can be compared with on-chain inference without a transaction.
+// With offchain set the model runs quantized on its calibration table and
+// with the operator fallbacks of the node instead, which transactions never
+// do.
+func (api *PublicSynapseAPI) Infer(ctx context.Context, modelHash string, input hexutil.Bytes, offchain *bool) (hexutil.Bytes, error) {
+	return api.infer(ctx, modelHash, "", input, offchain)
+}
+
+// InferByHash runs a model on an input published in the storage.
+func (api *PublicSynapseAPI) InferByHash(ctx context.Context, modelHash, inputHash string, offchain *bool) (hexutil.Bytes, error) {
+	return api.infer(ctx, modelHash, inputHash, nil, offchain)
+}
+
+func (api *PublicSynapseAPI) infer(ctx context.Context, modelHash, inputHash string, input []byte, offchain *bool) (hexutil.Bytes, error) {
+	if api.onnx != nil && api.onnx() {
+		modelHash = ONNXModel(modelHash)
+	}
+	if offchain != nil && *offchain {
+		modelHash += offchainSuffix
+	}
+	if timeout := api.s.config.InferTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	select {
+	case res := <-api.s.InferAsync(ctx, modelHash, inputHash, input):
+		return res.Data, res.Err
+	case <-ctx.Done():
+		return nil, ErrInferTimeout
+	}
+}
+
+// ModelAvailable reports whether a model is downloaded and expected to load,
+// the check the transaction pool runs before offering inference transactions
+// to the miner.
+func (api *PublicSynapseAPI) ModelAvailable(modelHash string, rawSize hexutil.Uint64) bool {
+	return api.s.ModelAvailable(modelHash, uint64(rawSize))
+}
+
+// AvailableModels returns the models loaded by the engine since it started.
+func (api *PublicSynapseAPI) AvailableModels() []string {
+	var models []string
+	api.s.metas.Range(func(k, v interface{}) bool {
+		models = append(models, "0x"+k.(string))
+		return true
+	})
+	sort.Strings(models)
+	return models
+}
+
+// AuditLog returns the recorded consensus inferences of blocks [from, to],
+// optionally restricted to one model.
+func (api *PublicSynapseAPI) AuditLog(from, to hexutil.Uint64, modelHash *string) ([]*AuditRecord, error) {
+	var model string
+	if modelHash != nil {
+		model = *modelHash
+	}
+	return api.s.AuditRecords(uint64(from), uint64(to), model)
+}
@@ -0,0 +1,119 @@
+package synapse
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/log"
+)
+
+// maxAuditRecords bounds the records returned by one audit query.
+const maxAuditRecords = 1024
+
+// AuditRecord documents one consensus inference, enough to reproduce it.
+type AuditRecord struct {
+	Block   uint64        `json:"block"`
+	Tx      common.Hash   `json:"tx"`
+	Model   string        `json:"model"`
+	Input   string        `json:"input"`
+	Output  string        `json:"output,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// auditLog is an append-only log of inference records, one json document
+// per line.
+type auditLog struct {
+	path string
+	lock sync.Mutex
+	file *os.File
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{path: path, file: f}, nil
+}
+
+func (a *auditLog) append(rec *AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_, err = a.file.Write(append(line, '\n'))
+	return err
+}
+
+// query returns the records of blocks [from, to], optionally restricted to
+// one model, oldest first.
+func (a *auditLog) query(from, to uint64, model string) ([]*AuditRecord, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []*AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() && len(records) < maxAuditRecords {
+		rec := new(AuditRecord)
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			continue // torn write of a crashed node
+		}
+		if rec.Block < from || rec.Block > to || (model != "" && rec.Model != model) {
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+func (a *auditLog) close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.file.Close()
+}
+
+// Audit records the outcome of a consensus inference. Inputs given as content
+// are identified by their hash, outputs always are.
+func (s *Synapse) Audit(block uint64, tx common.Hash, modelInfoHash, inputInfoHash string, output []byte, err error, elapsed time.Duration) {
+	if s.audit == nil {
+		return
+	}
+	rec := &AuditRecord{
+		Block:   block,
+		Tx:      tx,
+		Model:   strings.ToLower(modelInfoHash),
+		Input:   strings.ToLower(inputInfoHash),
+		Elapsed: elapsed,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	} else {
+		rec.Output = RLPHashString(output)
+	}
+	if err := s.audit.append(rec); err != nil {
+		log.Warn("Failed to write inference audit record", "err", err)
+	}
+}
+
+// AuditRecords returns the recorded inferences of blocks [from, to].
+func (s *Synapse) AuditRecords(from, to uint64, modelInfoHash string) ([]*AuditRecord, error) {
+	if s.audit == nil {
+		return nil, errAuditDisabled
+	}
+	return s.audit.query(from, to, strings.ToLower(modelInfoHash))
+}
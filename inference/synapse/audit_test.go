@@ -0,0 +1,45 @@
+package synapse
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	audit, err := openAuditLog(filepath.Join(dir, "audit", "log.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Synapse{audit: audit}
+	s.Audit(1, common.Hash{1}, "0xAA", "0xbb", []byte{1}, nil, time.Second)
+	s.Audit(2, common.Hash{2}, "0xcc", "0xdd", nil, KERNEL_RUNTIME_ERROR, time.Second)
+	s.Audit(3, common.Hash{3}, "0xaa", "0xee", []byte{2}, nil, time.Second)
+
+	records, err := s.AuditRecords(1, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Output != RLPHashString([]byte{1}) || records[1].Error == "" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	if records, _ = s.AuditRecords(0, 10, "0xAA"); len(records) != 2 || records[1].Block != 3 {
+		t.Fatalf("model filter returned %+v", records)
+	}
+	audit.close()
+
+	if _, err := (&Synapse{}).AuditRecords(0, 1, ""); !errors.Is(err, errAuditDisabled) {
+		t.Fatalf("err = %v, want errAuditDisabled", err)
+	}
+}
@@ -0,0 +1,34 @@
+package synapse
+
+import (
+	"strings"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+)
+
+// ModelAvailable reports whether a model is fully downloaded and expected to
+// load. It answers from the torrent state and the models seen so far, never
+// reading or loading model files, so it is cheap enough for transaction pool
+// admission. Models of a remote engine are always reported available, there
+// is no cheap way to ask.
+func (s *Synapse) ModelAvailable(modelInfoHash string, rawSize uint64) bool {
+	if s.config.IsRemoteInfer {
+		return true
+	}
+	if len(modelInfoHash) < 2 || !strings.HasPrefix(modelInfoHash, "0x") {
+		return false
+	}
+	modelHash := strings.ToLower(modelInfoHash[2:])
+	if _, ok := s.metas.Load(modelHash); ok {
+		return true
+	}
+	if err, ok := s.unloadable.Load(modelHash); ok {
+		log.Trace("Model known unloadable", "hash", modelHash, "err", err)
+		return false
+	}
+	if s.config.Storagefs == nil {
+		return false
+	}
+	ok, err := s.config.Storagefs.Available(s.ctx, modelHash, int64(rawSize))
+	return err == nil && ok
+}
@@ -0,0 +1,180 @@
+package synapse
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+)
+
+// DefaultBackend is the backend models run on when the config names none.
+const DefaultBackend = "cvm"
+
+// Capabilities describes the models a backend can run.
+type Capabilities struct {
+	Formats       []ModelFormat   // model formats the backend loads
+	Devices       []string        // device types, "cpu" or "cuda"
+	Deterministic bool            // results are bit-identical on every node
+	Ops           map[string]bool // operators implemented, nil for all native ones
+}
+
+// Model is a model loaded by a backend.
+type Model interface {
+	Ops() uint64
+	Size() uint64
+	GetInputLength() uint64
+	GetInputTypeSize() uint64
+	GetOutputTypeSize() uint64
+}
+
+// Backend executes models. Inference results are part of consensus: a
+// backend has to produce bit-identical results to the cvm runtime for the
+// models it accepts. Calls on one model are serialized by the engine.
+type Backend interface {
+	Capabilities() Capabilities
+	LoadModel(format ModelFormat, symbol, params []byte, deviceType string, deviceId int) (Model, error)
+	Infer(model Model, input []byte) ([]byte, error)
+	Free(model Model)
+}
+
+// GasEstimator is implemented by backends computing the gas of a model from
+// its graph, without loading it.
+type GasEstimator interface {
+	GraphGas(symbol []byte) (uint64, error)
+}
+
+// BackendFactory creates a backend for an engine config.
+type BackendFactory func(config *Config) (Backend, error)
+
+var (
+	backendLock      sync.RWMutex
+	backendFactories = make(map[string]BackendFactory)
+)
+
+// RegisterBackend makes a backend selectable by name in Config.Backends.
+func RegisterBackend(name string, factory BackendFactory) error {
+	backendLock.Lock()
+	defer backendLock.Unlock()
+	if _, ok := backendFactories[name]; ok {
+		return fmt.Errorf("backend %q already registered", name)
+	}
+	backendFactories[name] = factory
+	return nil
+}
+
+// Backends returns the names of the registered backends.
+func Backends() []string {
+	backendLock.RLock()
+	defer backendLock.RUnlock()
+	names := make([]string, 0, len(backendFactories))
+	for name := range backendFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// namedBackend is a backend opened by the engine.
+type namedBackend struct {
+	name string
+	Backend
+}
+
+// openBackends creates the configured backends in order of preference.
+// Backends failing to open are skipped.
+func openBackends(config *Config) []*namedBackend {
+	names := config.Backends
+	if len(names) == 0 {
+		names = []string{DefaultBackend}
+	}
+	var backends []*namedBackend
+	for _, name := range names {
+		backendLock.RLock()
+		factory, ok := backendFactories[name]
+		backendLock.RUnlock()
+		if !ok {
+			log.Error("Unknown inference backend", "name", name, "available", Backends())
+			continue
+		}
+		b, err := factory(config)
+		if err != nil {
+			log.Error("Failed to open inference backend", "name", name, "err", err)
+			continue
+		}
+		backends = append(backends, &namedBackend{name, b})
+	}
+	return backends
+}
+
+// selectBackend negotiates the backend of a model: the first one, in order
+// of preference, loading its format on the configured device, deterministic
+// if the engine is, and implementing all operators of its graph.
+func (s *Synapse) selectBackend(modelHash string, files *modelFiles) (*namedBackend, error) {
+	var ops []string
+	for _, b := range s.backends {
+		caps := b.Capabilities()
+		if !caps.supports(files.format, s.config.DeviceType) {
+			continue
+		}
+		if s.config.Deterministic && !caps.Deterministic {
+			continue
+		}
+		if caps.Ops != nil && files.format == FormatCVM {
+			if ops == nil {
+				ops = graphOps(files.symbol)
+			}
+			if missing := caps.missing(ops); len(missing) > 0 {
+				log.Debug("Backend lacks model operators", "model hash", modelHash, "backend", b.name, "ops", missing)
+				continue
+			}
+		}
+		return b, nil
+	}
+	log.Warn("No inference backend for model", "model hash", modelHash, "format", files.format, "device", s.config.DeviceType)
+	return nil, KERNEL_RUNTIME_ERROR
+}
+
+func (c *Capabilities) supports(format ModelFormat, deviceType string) bool {
+	if deviceType == "" {
+		deviceType = "cpu"
+	}
+	formatOk, deviceOk := false, false
+	for _, f := range c.Formats {
+		formatOk = formatOk || f == format
+	}
+	for _, d := range c.Devices {
+		deviceOk = deviceOk || d == deviceType
+	}
+	return formatOk && deviceOk
+}
+
+func (c *Capabilities) missing(ops []string) []string {
+	var missing []string
+	for _, op := range ops {
+		if !c.Ops[op] {
+			missing = append(missing, op)
+		}
+	}
+	return missing
+}
+
+// graphOps returns the distinct operators of a cvm symbol graph.
+func graphOps(symbol []byte) []string {
+	var graph struct {
+		Nodes []graphNode `json:"nodes"`
+	}
+	if err := json.Unmarshal(symbol, &graph); err != nil {
+		return []string{}
+	}
+	seen := make(map[string]bool)
+	ops := []string{}
+	for _, node := range graph.Nodes {
+		if op := opName(node.Attrs.FuncName); node.Op == "cvm_op" && !seen[op] {
+			seen[op] = true
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
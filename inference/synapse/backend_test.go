@@ -0,0 +1,63 @@
+package synapse
+
+import (
+	"testing"
+)
+
+type testBackend struct {
+	caps Capabilities
+}
+
+func (b *testBackend) Capabilities() Capabilities { return b.caps }
+
+func (b *testBackend) LoadModel(format ModelFormat, symbol, params []byte, deviceType string, deviceId int) (Model, error) {
+	return nil, KERNEL_RUNTIME_ERROR
+}
+
+func (b *testBackend) Infer(model Model, input []byte) ([]byte, error) {
+	return nil, KERNEL_RUNTIME_ERROR
+}
+
+func (b *testBackend) Free(model Model) {}
+
+func TestSelectBackend(t *testing.T) {
+	var (
+		dense = &modelFiles{format: FormatCVM, symbol: []byte(`{"nodes": [{"op":"null","name":"data"},{"op":"cvm_op","name":"fc","attrs":{"func_name":"dense"}}]}`)}
+		conv  = &modelFiles{format: FormatCVM, symbol: []byte(`{"nodes": [{"op":"null","name":"data"},{"op":"cvm_op","name":"c","attrs":{"func_name":"conv2d"}}]}`)}
+		onnx  = &modelFiles{format: FormatONNX}
+	)
+	s := &Synapse{
+		config: &Config{DeviceType: "cpu"},
+		backends: []*namedBackend{
+			{"gpu", &testBackend{Capabilities{Formats: []ModelFormat{FormatCVM}, Devices: []string{"cuda"}, Deterministic: true}}},
+			{"go", &testBackend{Capabilities{Formats: []ModelFormat{FormatCVM}, Devices: []string{"cpu"}, Deterministic: true, Ops: map[string]bool{"dense": true}}}},
+			{"float", &testBackend{Capabilities{Formats: []ModelFormat{FormatCVM, FormatONNX}, Devices: []string{"cpu"}}}},
+		},
+	}
+	tests := []struct {
+		files         *modelFiles
+		deterministic bool
+		want          string
+	}{
+		// The first backend implementing all operators wins.
+		{dense, false, "go"},
+		{conv, false, "float"},
+		{onnx, false, "float"},
+		// Deterministic engines only use deterministic backends.
+		{conv, true, ""},
+		{dense, true, "go"},
+	}
+	for i, tt := range tests {
+		s.config.Deterministic = tt.deterministic
+		b, err := s.selectBackend("aa", tt.files)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("test %d: backend %s selected, want none", i, b.name)
+		case tt.want != "" && (err != nil || b.name != tt.want):
+			t.Errorf("test %d: backend %v, %v, want %s", i, b, err, tt.want)
+		}
+	}
+	if err := RegisterBackend(DefaultBackend, newCVMBackend); err == nil {
+		t.Error("backend registered twice")
+	}
+}
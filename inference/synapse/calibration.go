@@ -0,0 +1,76 @@
+package synapse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+)
+
+// CALIBRATION_PATH is the optional int8 calibration table of a cvm model,
+// a json object mapping node names to the bit precision of their outputs.
+const CALIBRATION_PATH string = "/data/calibration"
+
+// maxCalibratedPrecision is the widest precision a calibration table may
+// assign; wider outputs wouldn't run on the int8 kernels.
+const maxCalibratedPrecision = 8
+
+// readCalibration returns the calibration table shipped with a model, if any.
+func (s *Synapse) readCalibration(modelHash string) (map[string]int, bool) {
+	data, err := s.ReadFile(s.ctx, TorrentURI(modelHash, CALIBRATION_PATH))
+	if err != nil || len(data) == 0 {
+		return nil, false
+	}
+	var table map[string]int
+	if err := json.Unmarshal(data, &table); err != nil {
+		log.Warn("Invalid calibration table", "model hash", modelHash, "err", err)
+		return nil, false
+	}
+	return table, true
+}
+
+// applyCalibration rewrites the output precision of the calibrated nodes in a
+// symbol graph, so the runtime quantizes them to the table's bit widths.
+func applyCalibration(symbol []byte, table map[string]int) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(symbol))
+	dec.UseNumber()
+	var graph map[string]interface{}
+	if err := dec.Decode(&graph); err != nil {
+		return nil, err
+	}
+	nodes, _ := graph["nodes"].([]interface{})
+	rowPtr, _ := graph["node_row_ptr"].([]interface{})
+	attrs, _ := graph["attrs"].(map[string]interface{})
+	precAttr, _ := attrs["precision"].([]interface{})
+	if len(rowPtr) != len(nodes)+1 || len(precAttr) != 2 {
+		return nil, fmt.Errorf("graph without entry precisions")
+	}
+	precisions, _ := precAttr[1].([]interface{})
+
+	applied := 0
+	for nid, n := range nodes {
+		node, _ := n.(map[string]interface{})
+		name, _ := node["name"].(string)
+		prec, ok := table[name]
+		if !ok {
+			continue
+		}
+		if prec <= 0 || prec > maxCalibratedPrecision {
+			return nil, fmt.Errorf("node %q: precision %d out of range", name, prec)
+		}
+		begin, err1 := rowPtr[nid].(json.Number).Int64()
+		end, err2 := rowPtr[nid+1].(json.Number).Int64()
+		if err1 != nil || err2 != nil || begin < 0 || end > int64(len(precisions)) {
+			return nil, fmt.Errorf("node %q: invalid entry range", name)
+		}
+		for eid := begin; eid < end; eid++ {
+			precisions[eid] = prec
+		}
+		applied++
+	}
+	if applied != len(table) {
+		return nil, fmt.Errorf("calibration table names %d unknown nodes", len(table)-applied)
+	}
+	return json.Marshal(graph)
+}
@@ -0,0 +1,88 @@
+package synapse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var calibrationSymbol = []byte(`{
+	"nodes": [{"op":"null","name":"data","inputs":[]},{"op":"cvm_op","name":"dense","inputs":[[0,0,0]],"attrs":{"func_name":"dense"}}],
+	"node_row_ptr": [0, 1, 2],
+	"attrs": {"precision": ["list_int", [8, 32]]}
+}`)
+
+func TestApplyCalibration(t *testing.T) {
+	symbol := calibrationSymbol
+	out, err := applyCalibration(symbol, map[string]int{"dense": 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var graph struct {
+		Attrs struct {
+			Precision []json.RawMessage `json:"precision"`
+		} `json:"attrs"`
+	}
+	if err := json.Unmarshal(out, &graph); err != nil {
+		t.Fatal(err)
+	}
+	var prec []int
+	json.Unmarshal(graph.Attrs.Precision[1], &prec)
+	if !reflect.DeepEqual(prec, []int{8, 8}) {
+		t.Fatalf("precision = %v, want [8 8]", prec)
+	}
+
+	if _, err := applyCalibration(symbol, map[string]int{"dense": 16}); err == nil {
+		t.Errorf("precision wider than int8 accepted")
+	}
+	if _, err := applyCalibration(symbol, map[string]int{"conv": 8}); err == nil {
+		t.Errorf("unknown node accepted")
+	}
+}
+
+func TestCalibrationOnlyOffchain(t *testing.T) {
+	root, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	files := map[string][]byte{
+		SYMBOL_PATH:      calibrationSymbol,
+		PARAM_PATH:       {0},
+		CALIBRATION_PATH: []byte(`{"dense": 8}`),
+	}
+	for path, data := range files {
+		name := filepath.Join(root, "aa", filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Synapse{config: &Config{}}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	defer s.cancel()
+	s.RegisterSource("torrent", NewDirSource(root))
+
+	// Transactions run the graph as published
+	plain, err := s.readModel("aa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.calibrated || !bytes.Equal(plain.symbol, calibrationSymbol) {
+		t.Errorf("model calibrated outside off-chain inference")
+	}
+	offchain, err := s.readModel("aa" + offchainSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !offchain.calibrated || bytes.Equal(offchain.symbol, calibrationSymbol) {
+		t.Errorf("off-chain variant not calibrated")
+	}
+}
@@ -0,0 +1,242 @@
+package synapse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+)
+
+// checkTimeout bounds the wait for the download of a model being checked.
+const checkTimeout = 5 * time.Minute
+
+// ModelCheck is the outcome of validating a model without executing it.
+type ModelCheck struct {
+	Hash          string   `json:"hash"`
+	Format        string   `json:"format"`
+	Backend       string   `json:"backend,omitempty"` // backend the model would run on
+	Size          uint64   `json:"size"`              // bytes of the model files
+	Params        uint64   `json:"params"`            // number of parameters, cvm models only
+	Memory        uint64   `json:"memory"`            // estimated bytes needed to run the model
+	InputShape    []int64  `json:"inputShape,omitempty"`
+	InputType     string   `json:"inputType,omitempty"`
+	Quantized     bool     `json:"quantized"`     // valid calibration table, for off-chain rpc inference
+	Deterministic bool     `json:"deterministic"` // runs on the integer kernels only
+	Unsupported   []string `json:"unsupported,omitempty"`
+	Errors        []string `json:"errors,omitempty"`
+	Ok            bool     `json:"ok"`
+}
+
+// CheckModel reads a model from the storage, downloading it first if needed,
+// and checks that it would load: its graph and parameters parse, every
+// operator has a kernel, and it fits the memory budget of the devices. The
+// model isn't loaded into the runtime.
+func (s *Synapse) CheckModel(ctx context.Context, modelInfoHash string) (*ModelCheck, error) {
+	if len(modelInfoHash) < 2 || !strings.HasPrefix(modelInfoHash, "0x") {
+		return nil, KERNEL_RUNTIME_ERROR
+	}
+	modelHash := strings.ToLower(modelInfoHash[2:])
+	// Checks accept onnx models, which only run from the ONNX fork on.
+	key := ONNXModel(modelHash)
+	if !s.modelDownloaded(key) && s.config.Storagefs != nil {
+		if err := s.config.Storagefs.Prioritize(ctx, modelHash); err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+		defer cancel()
+		for !s.modelDownloaded(key) {
+			select {
+			case <-time.After(preloadInterval):
+			case <-ctx.Done():
+				return nil, errors.New("model not downloaded yet")
+			}
+		}
+	}
+	files, err := s.readModel(key)
+	if err != nil {
+		return nil, err
+	}
+	check := checkModelFiles(modelHash, files)
+	s.checkCalibration(modelHash, files, check)
+	if len(s.backends) > 0 {
+		if b, err := s.selectBackend(modelHash, files); err != nil {
+			check.Errors = append(check.Errors, "no backend supports the model")
+			check.Ok = false
+		} else {
+			check.Backend = b.name
+		}
+	}
+	for _, d := range s.devices {
+		if int64(check.Memory) > d.budget {
+			check.Errors = append(check.Errors, fmt.Sprintf("needs %d bytes, device %d allows %d", check.Memory, d.id, d.budget))
+			check.Ok = false
+		}
+	}
+	return check, nil
+}
+
+// CheckModelDir checks the files of a model before its upload, laid out in
+// dir the way they are published, as data/symbol and data/params or as
+// data/model.onnx.
+func CheckModelDir(dir string) (*ModelCheck, error) {
+	s := &Synapse{config: &Config{}, ctx: context.Background()}
+	s.RegisterSource("torrent", &modelDirSource{dir})
+	files, err := s.readModel(ONNXModel(""))
+	if err != nil {
+		return nil, err
+	}
+	check := checkModelFiles("", files)
+	s.checkCalibration("", files, check)
+	return check, nil
+}
+
+// checkCalibration reports whether the calibration table of a model, if it
+// ships with one, applies to its graph for off-chain inference.
+func (s *Synapse) checkCalibration(modelHash string, files *modelFiles, check *ModelCheck) {
+	if files.format != FormatCVM {
+		return
+	}
+	table, ok := s.readCalibration(modelHash)
+	if !ok {
+		return
+	}
+	if _, err := applyCalibration(files.symbol, table); err != nil {
+		check.Errors = append(check.Errors, fmt.Sprintf("calibration table: %v", err))
+		check.Ok = false
+		return
+	}
+	check.Quantized = true
+}
+
+// modelDirSource serves the files of a single model from a directory.
+type modelDirSource struct {
+	dir string
+}
+
+func (m *modelDirSource) ReadFile(ctx context.Context, uri *url.URL) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(m.dir, filepath.FromSlash(uri.Path)))
+}
+
+// checkModelFiles validates the payload of a model.
+func checkModelFiles(modelHash string, files *modelFiles) *ModelCheck {
+	check := &ModelCheck{
+		Hash:   modelHash,
+		Format: files.format.String(),
+		Size:   uint64(len(files.symbol) + len(files.params)),
+	}
+	if files.format != FormatCVM {
+		// The graph is only converted at load time, the parameters are the
+		// bulk of what the runtime holds.
+		check.Size, check.Memory = uint64(len(files.params)), uint64(len(files.params))
+		check.Ok = true
+		return check
+	}
+	check.Deterministic = checkDeterministic(files) == nil
+
+	// Transactions run the graph as published, the fallbacks don't count.
+	if ops, err := unsupportedOps(files.symbol); err != nil {
+		check.Errors = append(check.Errors, err.Error())
+	} else if len(ops) > 0 {
+		check.Unsupported = ops
+	}
+	if shape, dtype, err := parseInputSignature(files.symbol); err != nil {
+		check.Errors = append(check.Errors, fmt.Sprintf("input signature: %v", err))
+	} else {
+		check.InputShape, check.InputType = shape, dtype
+	}
+	stats, err := parseGraphStats(files.symbol)
+	if err != nil {
+		check.Errors = append(check.Errors, fmt.Sprintf("graph: %v", err))
+	} else {
+		check.Params = stats.params
+		check.Memory = uint64(len(files.params)) + stats.activations
+		if stats.paramBytes > uint64(len(files.params)) {
+			check.Errors = append(check.Errors, fmt.Sprintf("params hold %d bytes, graph needs %d", len(files.params), stats.paramBytes))
+		}
+	}
+	check.Ok = len(check.Unsupported) == 0 && len(check.Errors) == 0
+	log.Debug("Model checked", "model hash", modelHash, "ok", check.Ok, "params", check.Params, "memory", check.Memory)
+	return check
+}
+
+// graphStats are the sizes of a cvm graph.
+type graphStats struct {
+	params      uint64 // elements of the parameter nodes
+	paramBytes  uint64 // bytes of the parameter nodes
+	activations uint64 // bytes of all node outputs, an upper bound of the runtime buffers
+}
+
+// parseGraphStats sums the shapes of a symbol graph. Null nodes other than
+// the input are parameters, the outputs of the others are activations.
+func parseGraphStats(symbol []byte) (*graphStats, error) {
+	var graph struct {
+		Nodes      []graphNode `json:"nodes"`
+		NodeRowPtr []int       `json:"node_row_ptr"`
+		Attrs      struct {
+			Shape  []json.RawMessage `json:"shape"`
+			DLType []json.RawMessage `json:"dltype"`
+		} `json:"attrs"`
+	}
+	if err := json.Unmarshal(symbol, &graph); err != nil {
+		return nil, err
+	}
+	if len(graph.Attrs.Shape) != 2 {
+		return nil, fmt.Errorf("no shape attribute")
+	}
+	var (
+		shapes [][]int64
+		types  []string
+	)
+	if err := json.Unmarshal(graph.Attrs.Shape[1], &shapes); err != nil {
+		return nil, err
+	}
+	if len(graph.Attrs.DLType) == 2 {
+		if err := json.Unmarshal(graph.Attrs.DLType[1], &types); err != nil {
+			return nil, err
+		}
+	}
+	stats := new(graphStats)
+	for nid, node := range graph.Nodes {
+		first, last := nid, nid+1
+		if nid+1 < len(graph.NodeRowPtr) {
+			first, last = graph.NodeRowPtr[nid], graph.NodeRowPtr[nid+1]
+		}
+		for eid := first; eid < last; eid++ {
+			if eid >= len(shapes) {
+				return nil, fmt.Errorf("entry %d of node %q out of range", eid, node.Name)
+			}
+			elems := shapeSize(shapes[eid])
+			dtype := "int32"
+			if eid < len(types) {
+				dtype = types[eid]
+			}
+			size := elems * dtypeSize(dtype)
+			switch {
+			case node.Op != "null":
+				stats.activations += size
+			case node.Name != "data":
+				stats.params += elems
+				stats.paramBytes += size
+			}
+		}
+	}
+	return stats, nil
+}
+
+// dtypeSize returns the bytes of an element of a graph type like int8 or
+// float32, 4 for types it doesn't know.
+func dtypeSize(dtype string) uint64 {
+	bits, err := strconv.Atoi(strings.TrimLeft(dtype, "abcdefghijklmnopqrstuvwxyz"))
+	if err != nil || bits <= 0 {
+		return 4
+	}
+	return uint64(bits+7) / 8
+}
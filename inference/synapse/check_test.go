@@ -0,0 +1,59 @@
+package synapse
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckModelDir(t *testing.T) {
+	symbol := `{
+		"nodes": [
+			{"op":"null","name":"data","inputs":[]},
+			{"op":"null","name":"fc_weight","inputs":[]},
+			{"op":"cvm_op","name":"fc","inputs":[[0,0,0],[1,0,0]],"attrs":{"func_name":"dense"}},
+			{"op":"cvm_op","name":"out","inputs":[[2,0,0]],"attrs":{"func_name":"test_gelu"}}
+		],
+		"node_row_ptr": [0, 1, 2, 3, 4],
+		"attrs": {"shape": ["list_shape", [[1, 8], [4, 8], [1, 4], [1, 4]]], "dltype": ["list_str", ["int8", "int8", "int32", "int32"]]}
+	}`
+	dir, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "data", "symbol"), []byte(symbol), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The weight needs 32 bytes.
+	if err := ioutil.WriteFile(filepath.Join(dir, "data", "params"), make([]byte, 16), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check, err := CheckModelDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Ok || check.Deterministic {
+		t.Errorf("model with unknown operator passed: ok %v, deterministic %v", check.Ok, check.Deterministic)
+	}
+	if !reflect.DeepEqual(check.Unsupported, []string{"test_gelu"}) {
+		t.Errorf("unsupported operators mismatch: have %v, want [test_gelu]", check.Unsupported)
+	}
+	if check.Params != 32 || check.Memory != 16+32 {
+		t.Errorf("sizes mismatch: have %d params %d bytes, want 32 params 48 bytes", check.Params, check.Memory)
+	}
+	if !reflect.DeepEqual(check.InputShape, []int64{1, 8}) || check.InputType != "int8" {
+		t.Errorf("input signature mismatch: have %v %s, want [1 8] int8", check.InputShape, check.InputType)
+	}
+	if len(check.Errors) != 1 {
+		t.Errorf("truncated params not reported: %v", check.Errors)
+	}
+	if _, err := CheckModelDir(filepath.Join(dir, "missing")); err != ErrModelMissing {
+		t.Errorf("missing model error mismatch: have %v, want %v", err, ErrModelMissing)
+	}
+}
@@ -0,0 +1,98 @@
+package synapse
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/CortexFoundation/CortexTheseus/cvm-runtime/kernel"
+	"github.com/CortexFoundation/CortexTheseus/log"
+)
+
+func init() {
+	RegisterBackend(DefaultBackend, newCVMBackend)
+}
+
+// cvmBackend runs models on the native cvm runtime plugins, the onnx plugin
+// being opened on first use so nodes without it keep working for cvm models.
+type cvmBackend struct {
+	deviceType string
+	lib        *kernel.LibCVM
+	onnxLib    *kernel.LibCVM
+	onnxOnce   sync.Once
+}
+
+func newCVMBackend(config *Config) (Backend, error) {
+	path := PLUGIN_PATH + config.DeviceType + PLUGIN_POST_FIX
+	lib, status := kernel.LibOpen(path)
+	if status != kernel.SUCCEED || lib == nil {
+		return nil, fmt.Errorf("failed to open cvm plugin %s", path)
+	}
+	return &cvmBackend{deviceType: config.DeviceType, lib: lib}, nil
+}
+
+func (b *cvmBackend) Capabilities() Capabilities {
+	return Capabilities{
+		Formats:       []ModelFormat{FormatCVM, FormatONNX},
+		Devices:       []string{b.deviceType},
+		Deterministic: b.deviceType == "cpu",
+	}
+}
+
+// runtime returns the plugin able to run models of the given format.
+func (b *cvmBackend) runtime(format ModelFormat) (*kernel.LibCVM, error) {
+	if format == FormatCVM {
+		return b.lib, nil
+	}
+	b.onnxOnce.Do(func() {
+		path := PLUGIN_PATH + ONNX_PLUGIN_PREFIX + b.deviceType + PLUGIN_POST_FIX
+		lib, status := kernel.LibOpen(path)
+		if status != kernel.SUCCEED {
+			log.Warn("ONNX runtime unavailable", "path", path)
+			return
+		}
+		b.onnxLib = lib
+	})
+	if b.onnxLib == nil {
+		return nil, KERNEL_RUNTIME_ERROR
+	}
+	return b.onnxLib, nil
+}
+
+func (b *cvmBackend) LoadModel(format ModelFormat, symbol, params []byte, deviceType string, deviceId int) (Model, error) {
+	lib, err := b.runtime(format)
+	if err != nil {
+		return nil, err
+	}
+	var device = 0
+	if deviceType == "cuda" {
+		device = 1
+	}
+	model, status := kernel.New(lib, symbol, params, device, deviceId)
+	// TODO(wlt): all returned runtime_error
+	if _, err := getReturnByStatusCode(model, status); err != nil {
+		return nil, KERNEL_RUNTIME_ERROR
+	}
+	return model, nil
+}
+
+func (b *cvmBackend) Infer(model Model, input []byte) ([]byte, error) {
+	result, status := model.(*kernel.Model).Predict(input)
+	// TODO(wlt): all returned runtime_error
+	if _, err := getReturnByStatusCode(result, status); err != nil {
+		return nil, KERNEL_RUNTIME_ERROR
+	}
+	return result, nil
+}
+
+func (b *cvmBackend) Free(model Model) {
+	model.(*kernel.Model).Free()
+}
+
+// GraphGas returns the gas of a cvm model from its symbol graph.
+func (b *cvmBackend) GraphGas(symbol []byte) (uint64, error) {
+	gas, status := kernel.GetModelGasFromGraphFile(b.lib, symbol)
+	if _, err := getReturnByStatusCode(gas, status); err != nil {
+		return 0, err
+	}
+	return gas, nil
+}
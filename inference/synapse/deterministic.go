@@ -0,0 +1,97 @@
+package synapse
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// deterministicOps are the integer operators of the cvm runtime, which yield
+// bit-identical results on every platform. These are the operators, and their
+// aliases, registered under cvm-runtime/src/cvm/top, looked up by opName.
+var deterministicOps = map[string]bool{
+	"abs": true, "broadcast_add": true, "broadcast_div": true, "broadcast_max": true,
+	"broadcast_mul": true, "broadcast_sub": true, "clip": true, "concatenate": true,
+	"conv2d": true, "cvm_clip": true, "cvm_left_shift": true, "cvm_lut": true,
+	"cvm_precision": true, "cvm_right_shift": true, "dense": true, "elemwise_add": true,
+	"elemwise_sub": true, "expand_dims": true, "flatten": true, "get_valid_counts": true,
+	"max": true, "max_pool2d": true, "negative": true, "non_max_suppression": true,
+	"relu": true, "repeat": true, "reshape": true, "slice": true, "slice_like": true,
+	"squeeze": true, "sum": true, "take": true, "tile": true, "transpose": true,
+	"upsampling": true,
+
+	// aliases
+	"__add_symbol__": true, "__div_symbol__": true, "__max_symbol__": true,
+	"__mul_symbol__": true, "__sub_symbol__": true, "add": true, "multiply": true,
+	"nn.relu": true, "strided_slice": true, "subtract": true,
+	"vision.non_max_suppression": true,
+}
+
+// opName returns the operator a func_name refers to, dropping the _<digits>
+// suffix the same way GetOpName of the cvm graph runtime does.
+func opName(funcName string) string {
+	i := len(funcName) - 1
+	for i >= 0 && funcName[i] >= '0' && funcName[i] <= '9' {
+		i--
+	}
+	if i >= 0 && funcName[i] == '_' {
+		return funcName[:i]
+	}
+	return funcName
+}
+
+// DeterministicError is returned when the deterministic mode of the node
+// refuses a model. The mode is a local policy, not an outcome every node
+// reaches: the error unwraps to KERNEL_RUNTIME_ERROR, so the node refuses the
+// block instead of failing a transaction other nodes run.
+type DeterministicError struct {
+	Model  string
+	Reason error
+}
+
+func (e *DeterministicError) Error() string {
+	return fmt.Sprintf("model %s refused in deterministic mode: %v", e.Model, e.Reason)
+}
+
+func (e *DeterministicError) Unwrap() error {
+	return KERNEL_RUNTIME_ERROR
+}
+
+func (e *DeterministicError) ErrorCode() ErrorCode {
+	return CodeOpUnsupported
+}
+
+// graphNode is the part of a cvm symbol node needed to identify its operator.
+type graphNode struct {
+	Op    string `json:"op"`
+	Name  string `json:"name"`
+	Attrs struct {
+		FuncName string `json:"func_name"`
+	} `json:"attrs"`
+}
+
+// checkDeterministic verifies that every operator of a model runs on the
+// integer kernel path. Formats other than cvm can't be checked and are
+// refused.
+func checkDeterministic(files *modelFiles) error {
+	if files.format != FormatCVM {
+		return fmt.Errorf("%v models can't be executed deterministically", files.format)
+	}
+	var graph struct {
+		Nodes []graphNode `json:"nodes"`
+	}
+	if err := json.Unmarshal(files.symbol, &graph); err != nil {
+		return fmt.Errorf("invalid symbol: %v", err)
+	}
+	for _, node := range graph.Nodes {
+		switch node.Op {
+		case "null":
+		case "cvm_op":
+			if !deterministicOps[opName(node.Attrs.FuncName)] {
+				return fmt.Errorf("nondeterministic operator %q in node %q", node.Attrs.FuncName, node.Name)
+			}
+		default:
+			return fmt.Errorf("unsupported node type %q in node %q", node.Op, node.Name)
+		}
+	}
+	return nil
+}
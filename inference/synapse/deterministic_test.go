@@ -0,0 +1,117 @@
+package synapse
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCheckDeterministic(t *testing.T) {
+	tests := []struct {
+		symbol string
+		ok     bool
+	}{
+		{`{"nodes":[{"op":"null","name":"data","inputs":[]},{"op":"cvm_op","name":"conv","inputs":[[0,0,0]],"attrs":{"func_name":"conv2d"}}]}`, true},
+		{`{"nodes":[{"op":"cvm_op","name":"act","inputs":[],"attrs":{"func_name":"nn.relu"}}]}`, true},
+		{`{"nodes":[{"op":"cvm_op","name":"conv","inputs":[],"attrs":{"func_name":"conv2d_12"}}]}`, true},
+		{`{"nodes":[{"op":"cvm_op","name":"drop","inputs":[],"attrs":{"func_name":"dropout"}}]}`, false},
+		{`{"nodes":[{"op":"tvm_op","name":"exp","inputs":[],"attrs":{"func_name":"exp"}}]}`, false},
+		{`not json`, false},
+	}
+	for i, tt := range tests {
+		err := checkDeterministic(&modelFiles{format: FormatCVM, symbol: []byte(tt.symbol)})
+		if (err == nil) != tt.ok {
+			t.Errorf("test %d: err = %v, want ok = %v", i, err, tt.ok)
+		}
+	}
+	if err := checkDeterministic(&modelFiles{format: FormatONNX}); err == nil {
+		t.Errorf("onnx model accepted in deterministic mode")
+	}
+}
+
+func TestOpName(t *testing.T) {
+	tests := map[string]string{
+		"conv2d":     "conv2d",
+		"conv2d_3":   "conv2d",
+		"max_pool2d": "max_pool2d",
+		"dense_":     "dense",
+		"nn.relu_10": "nn.relu",
+		"take_a1":    "take_a1",
+		// as in the runtime, which never finds these aliases by func_name
+		"__add_symbol__": "__add_symbol_",
+	}
+	for name, want := range tests {
+		if op := opName(name); op != want {
+			t.Errorf("opName(%q) = %q, want %q", name, op, want)
+		}
+	}
+}
+
+// TestDeterministicOpsRegistered keeps the operator table in sync with the
+// operators and aliases the cvm runtime registers.
+func TestDeterministicOpsRegistered(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "..", "cvm-runtime", "src", "cvm", "top", "*", "*.cc"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("runtime sources not found: %v", err)
+	}
+	re := regexp.MustCompile(`^(?:CVM_REGISTER_\w*OP\((\w+)|\.add_alias\("([^"]+)"\))`)
+	registered := make(map[string]bool)
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(string(src), "\n") {
+			if m := re.FindStringSubmatch(strings.TrimSpace(line)); m != nil && !strings.HasSuffix(line, "\\") {
+				registered[m[1]+m[2]] = true
+			}
+		}
+	}
+	for op := range registered {
+		if !deterministicOps[op] {
+			t.Errorf("runtime operator %q missing", op)
+		}
+	}
+	for op := range deterministicOps {
+		if !registered[op] {
+			t.Errorf("operator %q not registered by the runtime", op)
+		}
+	}
+}
+
+func TestDeterministicRefusalIsLocal(t *testing.T) {
+	root, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(root, "aa", "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "aa", "data", "model.onnx"), testONNX(7), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Synapse{
+		config:    &Config{Deterministic: true, DeviceIds: []int{0}, MaxMemoryUsage: MinMemoryUsage},
+		placement: make(map[string]*device),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	defer s.cancel()
+	s.devices = s.newDevices()
+	s.RegisterSource("torrent", NewDirSource(root))
+
+	// Nodes without the mode run the model, the refusal must not be
+	// recorded as a failed transaction.
+	_, _, err = s.acquireModel(ONNXModel("aa"))
+	if !errors.Is(err, KERNEL_RUNTIME_ERROR) || errors.Is(err, KERNEL_LOGIC_ERROR) {
+		t.Fatalf("refusal %v isn't a runtime error", err)
+	}
+	if code := ErrorCodeOf(err); code.Deterministic() {
+		t.Errorf("refusal encoded as deterministic code %v", code)
+	}
+}
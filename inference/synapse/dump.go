@@ -0,0 +1,118 @@
+package synapse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/log"
+)
+
+// InferenceDump captures one consensus inference, enough to rerun it offline
+// against the same model files. Inputs read from the storage are kept by
+// reference, inputs passed as content are kept verbatim.
+type InferenceDump struct {
+	Block     uint64        `json:"block"`
+	Tx        common.Hash   `json:"tx"`
+	Model     string        `json:"model"`
+	InputHash string        `json:"inputHash,omitempty"`
+	Input     hexutil.Bytes `json:"input,omitempty"`
+	Output    hexutil.Bytes `json:"output,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// dumpName returns the file name of a dump, unique per inference of a block.
+func dumpName(d *InferenceDump) string {
+	ref := d.InputHash
+	if ref == "" {
+		ref = RLPHashString([]byte(d.Input))
+	}
+	id := RLPHashString(d.Model + "_" + ref)
+	return fmt.Sprintf("%012d-%x-%s.json", d.Block, d.Tx[:8], id[2:18])
+}
+
+// Dump writes a consensus inference to the dump directory, a no-op unless
+// dumping is enabled. Inputs passed as content leave inputInfoHash empty.
+func (s *Synapse) Dump(block uint64, tx common.Hash, modelInfoHash, inputInfoHash string, inputContent, output []byte, err error) {
+	if s.config.DumpDir == "" {
+		return
+	}
+	d := &InferenceDump{
+		Block:     block,
+		Tx:        tx,
+		Model:     strings.ToLower(modelInfoHash),
+		InputHash: strings.ToLower(inputInfoHash),
+		Input:     inputContent,
+		Output:    output,
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	if err := writeDump(s.config.DumpDir, d); err != nil {
+		log.Warn("Failed to dump inference", "block", block, "tx", tx, "err", err)
+	}
+}
+
+func writeDump(dir string, d *InferenceDump) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, dumpName(d)), data, 0644)
+}
+
+// ReadDumps loads a single dump file, or all dumps of a directory ordered by
+// block.
+func ReadDumps(path string) ([]*InferenceDump, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+	dumps := make([]*InferenceDump, 0, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		d := new(InferenceDump)
+		if err := json.Unmarshal(data, d); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		dumps = append(dumps, d)
+	}
+	return dumps, nil
+}
+
+// Replay reruns a dumped inference on the local engine, bypassing the
+// inference queue.
+func (s *Synapse) Replay(d *InferenceDump) ([]byte, error) {
+	if d.InputHash != "" {
+		return s.inferByInfoHash(s.ctx, d.Model, d.InputHash)
+	}
+	return s.inferByInputContent(s.ctx, d.Model, d.Input)
+}
+
+// Matches reports whether a replayed inference agrees with the dumped one.
+func (d *InferenceDump) Matches(output []byte, err error) bool {
+	if err != nil || d.Error != "" {
+		return err != nil && err.Error() == d.Error
+	}
+	return bytes.Equal(output, d.Output)
+}
@@ -0,0 +1,83 @@
+package synapse
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+)
+
+func TestInferenceDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Synapse{config: &Config{DumpDir: filepath.Join(dir, "dump")}}
+	s.Dump(2, common.Hash{2}, "0xAA", "", []byte{1, 2}, []byte{3}, nil)
+	s.Dump(1, common.Hash{1}, "0xaa", "0xBB", nil, nil, KERNEL_RUNTIME_ERROR)
+	s.Dump(1, common.Hash{1}, "0xaa", "0xcc", nil, []byte{4}, nil)
+
+	dumps, err := ReadDumps(s.config.DumpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dumps) != 3 {
+		t.Fatalf("%d dumps, want 3", len(dumps))
+	}
+	if dumps[0].Block != 1 || dumps[1].Block != 1 || dumps[2].Block != 2 {
+		t.Fatalf("dumps not ordered by block: %d %d %d", dumps[0].Block, dumps[1].Block, dumps[2].Block)
+	}
+	content := dumps[2]
+	if content.Model != "0xaa" || content.InputHash != "" || string(content.Input) != "\x01\x02" {
+		t.Fatalf("content dump mismatch: %+v", content)
+	}
+	if !content.Matches([]byte{3}, nil) || content.Matches([]byte{4}, nil) || content.Matches(nil, KERNEL_RUNTIME_ERROR) {
+		t.Fatal("content dump matched the wrong output")
+	}
+	for _, d := range dumps[:2] {
+		if d.InputHash == "0xbb" {
+			if !d.Matches(nil, KERNEL_RUNTIME_ERROR) || d.Matches(nil, errors.New("other")) || d.Matches([]byte{4}, nil) {
+				t.Fatal("failed dump matched the wrong outcome")
+			}
+		}
+	}
+
+	single, err := ReadDumps(filepath.Join(s.config.DumpDir, dumpName(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(single) != 1 || single[0].Block != 2 {
+		t.Fatalf("single dump mismatch: %+v", single)
+	}
+}
+
+func TestDirSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "aa", "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "aa", "data", "symbol"), []byte("sym"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Synapse{config: &Config{}}
+	s.registerDefaultSources()
+	s.RegisterSource("torrent", NewDirSource(dir))
+	data, err := s.ReadFile(context.Background(), TorrentURI("0xAA", "/data/symbol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "sym" {
+		t.Fatalf("read %q, want %q", data, "sym")
+	}
+}
@@ -2,9 +2,146 @@ package synapse
 
 import (
 	"errors"
+	"fmt"
 )
 
 var (
 	KERNEL_RUNTIME_ERROR = errors.New("cvm kernel runtime error")
 	KERNEL_LOGIC_ERROR   = errors.New("cvm kernel logic error")
+
+	errAuditDisabled = errors.New("inference audit log disabled")
+)
+
+// ErrorCode identifies why an inference failed. Every code belongs to one of
+// two classes: codes unwrapping to KERNEL_LOGIC_ERROR are deterministic and
+// fail the transaction on every node, codes unwrapping to KERNEL_RUNTIME_ERROR
+// are local to the node, which then refuses to process the block instead of
+// recording an outcome other nodes might not reach.
+type ErrorCode uint8
+
+// ErrorCodeVersion is the version of the code table. Codes are never
+// renumbered or moved to the other class, new ones bump the version. In
+// version 3 every code but the decoding failures of the model and input and
+// the unclassified logic error is local to the node, worker crashes
+// included.
+const ErrorCodeVersion = 3
+
+const (
+	CodeRuntime        ErrorCode = 0x01 // unclassified local failure
+	CodeLogic          ErrorCode = 0x02 // unclassified deterministic failure
+	CodeModelMissing   ErrorCode = 0x03 // model files not readable from the storage
+	CodeInputMissing   ErrorCode = 0x04 // input file not readable from the storage
+	CodeModelMalformed ErrorCode = 0x05 // model files can't be decoded
+	CodeInputMalformed ErrorCode = 0x06 // input file can't be decoded
+	CodeInputMismatch  ErrorCode = 0x07 // input doesn't fit the model signature
+	CodeOpUnsupported  ErrorCode = 0x08 // operator without kernel or fallback
+	CodeTimeout        ErrorCode = 0x09 // inference missed its deadline
+	CodeSandbox        ErrorCode = 0x0a // sandboxed worker exceeded its limits or died, since version 2
+	CodeCrash          ErrorCode = 0x0b // native code crashed a fresh worker twice, local to the build and machine, since version 3
 )
+
+var errorCodes = map[ErrorCode]struct {
+	name  string
+	class error
+}{
+	CodeRuntime:        {"runtime error", KERNEL_RUNTIME_ERROR},
+	CodeLogic:          {"logic error", KERNEL_LOGIC_ERROR},
+	CodeModelMissing:   {"model missing", KERNEL_RUNTIME_ERROR},
+	CodeInputMissing:   {"input missing", KERNEL_RUNTIME_ERROR},
+	CodeModelMalformed: {"model malformed", KERNEL_LOGIC_ERROR},
+	CodeInputMalformed: {"input malformed", KERNEL_LOGIC_ERROR},
+	CodeInputMismatch:  {"input mismatch", KERNEL_RUNTIME_ERROR},
+	CodeOpUnsupported:  {"operator unsupported", KERNEL_RUNTIME_ERROR},
+	CodeTimeout:        {"inference timed out", KERNEL_RUNTIME_ERROR},
+	CodeSandbox:        {"sandbox limit exceeded", KERNEL_RUNTIME_ERROR},
+	CodeCrash:          {"worker crashed", KERNEL_RUNTIME_ERROR},
+}
+
+func (c ErrorCode) String() string {
+	if e, ok := errorCodes[c]; ok {
+		return e.name
+	}
+	return fmt.Sprintf("unknown error code %d", c)
+}
+
+// Deterministic reports whether all nodes agree on a failure with this code.
+func (c ErrorCode) Deterministic() bool {
+	e, ok := errorCodes[c]
+	return ok && e.class == KERNEL_LOGIC_ERROR
+}
+
+// InferError is a classified inference failure.
+type InferError struct {
+	Code ErrorCode
+}
+
+func (e *InferError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Code, e.Unwrap())
+}
+
+func (e *InferError) Unwrap() error {
+	if c, ok := errorCodes[e.Code]; ok {
+		return c.class
+	}
+	return KERNEL_RUNTIME_ERROR
+}
+
+func (e *InferError) ErrorCode() ErrorCode {
+	return e.Code
+}
+
+var (
+	ErrModelMissing   = &InferError{CodeModelMissing}
+	ErrInputMissing   = &InferError{CodeInputMissing}
+	ErrModelMalformed = &InferError{CodeModelMalformed}
+	ErrInputMalformed = &InferError{CodeInputMalformed}
+	ErrSandbox        = &InferError{CodeSandbox}
+	ErrWorkerCrashed  = &InferError{CodeCrash}
+)
+
+// canonicalErrors are the errors decoded codes map back to, so a decoded
+// failure compares equal to the one the remote node returned.
+var canonicalErrors = map[ErrorCode]error{
+	CodeRuntime:        KERNEL_RUNTIME_ERROR,
+	CodeLogic:          KERNEL_LOGIC_ERROR,
+	CodeModelMissing:   ErrModelMissing,
+	CodeInputMissing:   ErrInputMissing,
+	CodeModelMalformed: ErrModelMalformed,
+	CodeInputMalformed: ErrInputMalformed,
+	CodeInputMismatch:  &InferError{CodeInputMismatch},
+	CodeOpUnsupported:  &InferError{CodeOpUnsupported},
+	CodeTimeout:        ErrInferTimeout,
+	CodeSandbox:        ErrSandbox,
+	CodeCrash:          ErrWorkerCrashed,
+}
+
+// ErrorCodeOf classifies an inference error. Errors without a code of their
+// own fall back to the unclassified code of their class.
+func ErrorCodeOf(err error) ErrorCode {
+	var coded interface{ ErrorCode() ErrorCode }
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+	if errors.Is(err, KERNEL_LOGIC_ERROR) {
+		return CodeLogic
+	}
+	return CodeRuntime
+}
+
+// EncodeError returns the versioned encoding of an inference error.
+func EncodeError(err error) []byte {
+	return []byte{ErrorCodeVersion, byte(ErrorCodeOf(err))}
+}
+
+// DecodeError reverses EncodeError. Encodings of unknown versions or codes
+// decode to KERNEL_RUNTIME_ERROR, so a node never records a failure it can't
+// interpret as a deterministic outcome.
+func DecodeError(enc []byte) error {
+	if len(enc) != 2 || enc[0] == 0 || enc[0] > ErrorCodeVersion {
+		return KERNEL_RUNTIME_ERROR
+	}
+	if err, ok := canonicalErrors[ErrorCode(enc[1])]; ok {
+		return err
+	}
+	return KERNEL_RUNTIME_ERROR
+}
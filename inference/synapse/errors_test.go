@@ -0,0 +1,55 @@
+package synapse
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+	tests := []struct {
+		err           error
+		code          ErrorCode
+		deterministic bool
+	}{
+		{KERNEL_RUNTIME_ERROR, CodeRuntime, false},
+		{KERNEL_LOGIC_ERROR, CodeLogic, true},
+		{fmt.Errorf("%w: wrapped", KERNEL_LOGIC_ERROR), CodeLogic, true},
+		{errors.New("unclassified"), CodeRuntime, false},
+		{ErrModelMissing, CodeModelMissing, false},
+		{ErrInputMissing, CodeInputMissing, false},
+		{ErrModelMalformed, CodeModelMalformed, true},
+		{ErrInputMalformed, CodeInputMalformed, true},
+		{&InputError{Model: "aa", Reason: "length"}, CodeInputMismatch, false},
+		{&UnsupportedOperatorError{Model: "aa", Ops: []string{"conv2d"}}, CodeOpUnsupported, false},
+		{&DeterministicError{Model: "aa", Reason: errors.New("onnx")}, CodeOpUnsupported, false},
+		{ErrInferTimeout, CodeTimeout, false},
+		{ErrSandbox, CodeSandbox, false},
+		{ErrWorkerCrashed, CodeCrash, false},
+	}
+	for i, tt := range tests {
+		code := ErrorCodeOf(tt.err)
+		if code != tt.code {
+			t.Errorf("test %d: code %v, want %v", i, code, tt.code)
+		}
+		if code.Deterministic() != tt.deterministic {
+			t.Errorf("test %d: deterministic %v, want %v", i, code.Deterministic(), tt.deterministic)
+		}
+		// The class of a decoded error is what decides consensus.
+		decoded := DecodeError(EncodeError(tt.err))
+		if ErrorCodeOf(decoded) != tt.code {
+			t.Errorf("test %d: decoded code %v, want %v", i, ErrorCodeOf(decoded), tt.code)
+		}
+		if errors.Is(decoded, KERNEL_LOGIC_ERROR) != tt.deterministic || errors.Is(tt.err, KERNEL_LOGIC_ERROR) != tt.deterministic {
+			t.Errorf("test %d: class of %v differs from code %v", i, tt.err, code)
+		}
+	}
+}
+
+func TestDecodeUnknownError(t *testing.T) {
+	for _, enc := range [][]byte{nil, {}, {ErrorCodeVersion}, {0, byte(CodeLogic)}, {ErrorCodeVersion + 1, byte(CodeLogic)}, {ErrorCodeVersion, 0xff}} {
+		if err := DecodeError(enc); err != KERNEL_RUNTIME_ERROR {
+			t.Errorf("encoding %x decoded to %v", enc, err)
+		}
+	}
+}
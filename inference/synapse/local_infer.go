@@ -1,10 +1,11 @@
 package synapse
 
 import (
+	"context"
 	"strings"
+	"time"
 	//"sync"
 
-	"github.com/CortexFoundation/CortexTheseus/common/lru"
 	"github.com/CortexFoundation/CortexTheseus/cvm-runtime/kernel"
 	"github.com/CortexFoundation/CortexTheseus/inference"
 	"github.com/CortexFoundation/CortexTheseus/log"
@@ -53,35 +54,56 @@ func (s *Synapse) getGasByInfoHash(modelInfoHash string) (uint64, error) {
 		return v.(uint64), nil
 	}
 
-	modelJson, modelJson_err := s.config.Storagefs.GetFile(s.ctx, modelHash, SYMBOL_PATH)
-	if modelJson_err != nil || modelJson == nil {
+	var (
+		gas       uint64
+		estimator GasEstimator
+	)
+	for _, b := range s.backends {
+		if e, ok := b.Backend.(GasEstimator); ok {
+			estimator = e
+			break
+		}
+	}
+	hash, variant := splitModelKey(modelHash)
+	modelJson, modelJson_err := s.ReadFile(s.ctx, TorrentURI(hash, SYMBOL_PATH))
+	if modelJson_err == nil && modelJson != nil && estimator != nil {
+		var err error
+		if gas, err = estimator.GraphGas(modelJson); err != nil {
+			return 0, err
+		}
+	} else if modelJson == nil && !variant.onnx {
 		log.Warn("GetGasByInfoHash: get file failed", "error", modelJson_err, "hash", modelInfoHash)
 		return 0, KERNEL_RUNTIME_ERROR
-	}
-
-	//var status int
-	gas, status := kernel.GetModelGasFromGraphFile(s.lib, modelJson)
-	_, err := getReturnByStatusCode(gas, status)
-	if err != nil {
-		return 0, err
+	} else {
+		// Models without a symbol graph (onnx) report their ops once loaded.
+		d := s.acquireDevice(modelHash)
+		mc, err := s.loadModel(d, modelHash)
+		if err == nil {
+			gas = mc.model.Ops()
+		}
+		s.releaseDevice(d)
+		if err != nil {
+			log.Warn("GetGasByInfoHash: get file failed", "error", modelJson_err, "hash", modelInfoHash)
+			return 0, err
+		}
 	}
 
 	if !s.config.IsNotCache {
 		gasCacheMissMeter.Mark(1)
 		s.gasCache.Store(cacheKey, gas)
 	}
-	return gas, err
+	return gas, nil
 }
 
-func (s *Synapse) inferByInfoHash(modelInfoHash, inputInfoHash string) ([]byte, error) {
-	return s.infer(modelInfoHash, inputInfoHash, nil)
+func (s *Synapse) inferByInfoHash(ctx context.Context, modelInfoHash, inputInfoHash string) ([]byte, error) {
+	return s.infer(ctx, modelInfoHash, inputInfoHash, nil)
 }
 
-func (s *Synapse) inferByInputContent(modelInfoHash string, inputContent []byte) ([]byte, error) {
-	return s.infer(modelInfoHash, "", inputContent)
+func (s *Synapse) inferByInputContent(ctx context.Context, modelInfoHash string, inputContent []byte) ([]byte, error) {
+	return s.infer(ctx, modelInfoHash, "", inputContent)
 }
 
-func (s *Synapse) infer(modelInfoHash, inputInfoHash string, inputContent []byte) ([]byte, error) {
+func (s *Synapse) infer(ctx context.Context, modelInfoHash, inputInfoHash string, inputContent []byte) ([]byte, error) {
 	if inputInfoHash == "" {
 		inputInfoHash = RLPHashString(inputContent)
 	}
@@ -101,94 +123,92 @@ func (s *Synapse) infer(modelInfoHash, inputInfoHash string, inputContent []byte
 		return hash, nil
 	}
 
-	if v, ok := s.simpleCache.Load(cacheKey); ok && !s.config.IsNotCache {
-		log.Debug("Infer Succeed via Cache", "result", v.([]byte))
-		simpleCacheHitMeter.Mark(1)
-		return v.([]byte), nil
-	}
-
-	if inputContent == nil {
-		inputBytes, dataErr := s.config.Storagefs.GetFile(s.ctx, inputHash, DATA_PATH)
-		if dataErr != nil {
-			return nil, KERNEL_RUNTIME_ERROR
-		}
-		reader, reader_err := inference.NewBytesReader(inputBytes)
-		if reader_err != nil {
-			return nil, KERNEL_LOGIC_ERROR
-		}
-		var read_data_err error
-		inputContent, read_data_err = ReadData(reader)
-		if read_data_err != nil {
-			return nil, KERNEL_LOGIC_ERROR
+	if s.simpleCache != nil {
+		if v, ok := s.simpleCache.Get(cacheKey); ok {
+			log.Debug("Infer Succeed via Cache", "result", v.([]byte))
+			simpleCacheHitMeter.Mark(1)
+			resultCacheHitCounter.Inc(1)
+			return v.([]byte), nil
 		}
+		resultCacheMissCounter.Inc(1)
 	}
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	// lazy initialization of model cache
-	if _, ok := s.caches[s.config.DeviceId]; !ok {
-		memoryUsage := s.config.MaxMemoryUsage
-		if memoryUsage < MinMemoryUsage {
-			memoryUsage = MinMemoryUsage
-		}
-		memoryUsage -= ReservedMemoryUsage
-		log.Info("Memory alloc", "size", memoryUsage)
-		s.caches[s.config.DeviceId] = lru.New(memoryUsage)
-		s.caches[s.config.DeviceId].OnEvicted = func(key lru.Key, value interface{}) {
-			log.Warn("C FREE On Evicted", "k", key, "size", value.(*kernel.Model).Size(), "max", s.config.MaxMemoryUsage, "min", MinMemoryUsage)
-			value.(*kernel.Model).Free()
+	if inputContent == nil {
+		input, release, err := s.readInput(inputHash)
+		if err != nil {
+			return nil, err
 		}
+		defer release()
+		inputContent = input
 	}
 
 	var (
 		result []byte
-		model  *kernel.Model
-		status int
+		err    error
+		start  = time.Now()
 	)
-
-	//v, _ := s.modelLock.LoadOrStore(modelHash, sync.Mutex{})
-	//mutex := v.(sync.Mutex)
-
-	model_tmp, has_model := s.caches[s.config.DeviceId].Get(modelHash)
-	if !has_model {
-		modelJson, modelJson_err := s.config.Storagefs.GetFile(s.ctx, modelHash, SYMBOL_PATH)
-		if modelJson_err != nil || modelJson == nil {
-			log.Warn("inferByInputContent: model loaded failed", "model hash", modelHash, "error", modelJson_err)
-			return nil, KERNEL_RUNTIME_ERROR
-		}
-		modelParams, modelParams_err := s.config.Storagefs.GetFile(s.ctx, modelHash, PARAM_PATH)
-		if modelParams_err != nil || modelParams == nil {
-			log.Warn("inferByInputContent: params loaded failed", "model hash", modelHash, "error", modelParams_err)
-			return nil, KERNEL_RUNTIME_ERROR
-		}
-		var deviceType = 0
-		if s.config.DeviceType == "cuda" {
-			deviceType = 1
-		}
-		model, status = kernel.New(s.lib, modelJson, modelParams, deviceType, s.config.DeviceId)
-		// TODO(wlt): all returned runtime_error
-		if _, err := getReturnByStatusCode(model, status); err != nil {
-			return nil, KERNEL_RUNTIME_ERROR
-		}
-		s.caches[s.config.DeviceId].Add(modelHash, model, int64(model.Size()))
+	if s.sandbox != nil {
+		result, err = s.sandbox.infer(ctx, modelInfoHash, inputContent)
 	} else {
-		model = model_tmp.(*kernel.Model)
+		result, err = s.predict(modelHash, inputContent)
 	}
-	log.Trace("iput content", "input", inputContent, "len", len(inputContent))
-	result, status = model.Predict(inputContent)
-	// TODO(wlt): all returned runtime_error
-	if _, err := getReturnByStatusCode(result, status); err != nil {
-		return nil, KERNEL_RUNTIME_ERROR
+	inferTimer.UpdateSince(start)
+	modelTimer(modelHash, "infer").UpdateSince(start)
+	if err != nil {
+		return nil, err
 	}
 
-	if !s.config.IsNotCache {
+	if s.simpleCache != nil {
 		simpleCacheMissMeter.Mark(1)
-		s.simpleCache.Store(cacheKey, result)
+		s.simpleCache.Add(cacheKey, result)
 	}
 
 	return result, nil
 }
 
+// readInput reads and decodes the input file of a torrent. Large inputs are
+// mapped and decoded in place rather than copied twice; the decoded input is
+// valid until release is called.
+func (s *Synapse) readInput(inputHash string) (input []byte, release func(), err error) {
+	uri := TorrentURI(inputHash, DATA_PATH)
+	if data, unmap, err := s.MapFile(s.ctx, uri); err == nil {
+		if input, err = ReadDataInPlace(data); err != nil {
+			unmap()
+			return nil, nil, ErrInputMalformed
+		}
+		return input, unmap, nil
+	}
+	inputBytes, dataErr := s.ReadFile(s.ctx, uri)
+	if dataErr != nil {
+		return nil, nil, ErrInputMissing
+	}
+	reader, reader_err := inference.NewBytesReader(inputBytes)
+	if reader_err != nil {
+		return nil, nil, ErrInputMalformed
+	}
+	if input, err = ReadData(reader); err != nil {
+		return nil, nil, ErrInputMalformed
+	}
+	return input, func() {}, nil
+}
+
+// predict runs a model on an input in the node process.
+func (s *Synapse) predict(modelHash string, inputContent []byte) ([]byte, error) {
+	d, mc, err := s.acquireModel(modelHash)
+	if err != nil {
+		return nil, err
+	}
+	defer s.releaseModel(d, mc)
+	if meta, ok := s.ModelMeta(modelHash); ok {
+		if err := meta.ValidateInput(inputContent); err != nil {
+			log.Debug("Inference input rejected", "err", err)
+			return nil, err
+		}
+	}
+	log.Trace("iput content", "input", inputContent, "len", len(inputContent))
+	return mc.backend.Infer(mc.model, inputContent)
+}
+
 func (s *Synapse) Available(infoHash string, rawSize int64) error {
 	if s.config.IsRemoteInfer {
 		errRes := s.remoteAvailable(
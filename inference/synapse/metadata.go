@@ -0,0 +1,154 @@
+package synapse
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+)
+
+// ModelMeta is the signature of a loaded model.
+type ModelMeta struct {
+	Hash           string  `json:"hash"`
+	Format         string  `json:"format"`
+	Backend        string  `json:"backend,omitempty"`
+	InputShape     []int64 `json:"inputShape,omitempty"`
+	InputType      string  `json:"inputType,omitempty"`
+	InputLength    uint64  `json:"inputLength"`
+	InputTypeSize  uint64  `json:"inputTypeSize"`
+	OutputTypeSize uint64  `json:"outputTypeSize"`
+	Ops            uint64  `json:"ops"`
+	Size           uint64  `json:"size"`
+	Quantized      bool    `json:"quantized"`
+}
+
+// InputError is returned when an inference input doesn't match the signature
+// of the model. It unwraps to KERNEL_RUNTIME_ERROR, the error inference has
+// always reported for inputs refused by the native runtime.
+type InputError struct {
+	Model  string
+	Reason string
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("invalid input for model %s: %s", e.Model, e.Reason)
+}
+
+func (e *InputError) Unwrap() error {
+	return KERNEL_RUNTIME_ERROR
+}
+
+func (e *InputError) ErrorCode() ErrorCode {
+	return CodeInputMismatch
+}
+
+// newModelMeta builds the signature of a model from the runtime and, for cvm
+// models, from the symbol graph.
+func newModelMeta(modelHash string, files *modelFiles, model Model) *ModelMeta {
+	meta := &ModelMeta{
+		Hash:           modelHash,
+		Format:         files.format.String(),
+		InputLength:    model.GetInputLength(),
+		InputTypeSize:  model.GetInputTypeSize(),
+		OutputTypeSize: model.GetOutputTypeSize(),
+		Ops:            model.Ops(),
+		Size:           model.Size(),
+		Quantized:      files.calibrated,
+	}
+	if files.format != FormatCVM {
+		return meta
+	}
+	shape, dtype, err := parseInputSignature(files.symbol)
+	if err != nil {
+		log.Debug("Model input signature unavailable", "model hash", modelHash, "err", err)
+		return meta
+	}
+	meta.InputShape, meta.InputType = shape, dtype
+	if n := shapeSize(shape); n > 0 && meta.InputTypeSize > 0 && n*meta.InputTypeSize != meta.InputLength {
+		log.Warn("Model input shape mismatches runtime length", "model hash", modelHash, "shape", shape, "length", meta.InputLength)
+	}
+	return meta
+}
+
+// ValidateInput checks an input against the signature before it reaches the
+// native runtime. Like Predict, trailing bytes beyond the input length are
+// ignored.
+func (m *ModelMeta) ValidateInput(input []byte) error {
+	if uint64(len(input)) < m.InputLength {
+		return &InputError{m.Hash, fmt.Sprintf("length %d, want %d", len(input), m.InputLength)}
+	}
+	if m.InputTypeSize > 1 && m.InputLength%m.InputTypeSize != 0 {
+		return &InputError{m.Hash, fmt.Sprintf("length %d not aligned to %d byte elements", m.InputLength, m.InputTypeSize)}
+	}
+	return nil
+}
+
+// parseInputSignature returns the shape and type of the "data" node of a
+// symbol graph.
+func parseInputSignature(symbol []byte) ([]int64, string, error) {
+	var graph struct {
+		Nodes      []graphNode `json:"nodes"`
+		NodeRowPtr []int       `json:"node_row_ptr"`
+		Attrs      struct {
+			Shape  []json.RawMessage `json:"shape"`
+			DLType []json.RawMessage `json:"dltype"`
+		} `json:"attrs"`
+	}
+	if err := json.Unmarshal(symbol, &graph); err != nil {
+		return nil, "", err
+	}
+	nid := -1
+	for i, node := range graph.Nodes {
+		if node.Op == "null" && node.Name == "data" {
+			nid = i
+			break
+		}
+	}
+	if nid < 0 {
+		return nil, "", fmt.Errorf("no data node")
+	}
+	eid := nid
+	if nid < len(graph.NodeRowPtr) {
+		eid = graph.NodeRowPtr[nid]
+	}
+	if len(graph.Attrs.Shape) != 2 {
+		return nil, "", fmt.Errorf("no shape attribute")
+	}
+	var shapes [][]int64
+	if err := json.Unmarshal(graph.Attrs.Shape[1], &shapes); err != nil {
+		return nil, "", err
+	}
+	if eid >= len(shapes) {
+		return nil, "", fmt.Errorf("data entry %d out of range", eid)
+	}
+	var dtype string
+	if len(graph.Attrs.DLType) == 2 {
+		var types []string
+		if err := json.Unmarshal(graph.Attrs.DLType[1], &types); err == nil && eid < len(types) {
+			dtype = types[eid]
+		}
+	}
+	return shapes[eid], dtype, nil
+}
+
+func shapeSize(shape []int64) uint64 {
+	if len(shape) == 0 {
+		return 0
+	}
+	n := uint64(1)
+	for _, d := range shape {
+		if d <= 0 {
+			return 0
+		}
+		n *= uint64(d)
+	}
+	return n
+}
+
+// ModelMeta returns the signature of a model loaded since the engine started.
+func (s *Synapse) ModelMeta(modelHash string) (*ModelMeta, bool) {
+	if v, ok := s.metas.Load(modelHash); ok {
+		return v.(*ModelMeta), true
+	}
+	return nil, false
+}
@@ -0,0 +1,34 @@
+package synapse
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseInputSignature(t *testing.T) {
+	symbol := `{
+		"nodes": [{"op":"null","name":"data","inputs":[]},{"op":"cvm_op","name":"dense","inputs":[[0,0,0]],"attrs":{"func_name":"dense"}}],
+		"node_row_ptr": [0, 1, 2],
+		"attrs": {"shape": ["list_shape", [[1, 3, 28, 28], [1, 10]]], "dltype": ["list_str", ["int8", "int32"]]}
+	}`
+	shape, dtype, err := parseInputSignature([]byte(symbol))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(shape, []int64{1, 3, 28, 28}) || dtype != "int8" {
+		t.Fatalf("signature = %v %s, want [1 3 28 28] int8", shape, dtype)
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	meta := &ModelMeta{Hash: "m", InputLength: 8, InputTypeSize: 4}
+	if err := meta.ValidateInput(make([]byte, 10)); err != nil {
+		t.Fatalf("longer input refused: %v", err)
+	}
+	err := meta.ValidateInput(make([]byte, 4))
+	var inputErr *InputError
+	if !errors.As(err, &inputErr) || !errors.Is(err, KERNEL_RUNTIME_ERROR) {
+		t.Fatalf("short input error = %v, want InputError", err)
+	}
+}
@@ -0,0 +1,33 @@
+package synapse
+
+import (
+	"github.com/CortexFoundation/CortexTheseus/metrics"
+)
+
+var (
+	inferTimer = metrics.NewRegisteredTimer("synapse/infer/latency", nil)
+	loadTimer  = metrics.NewRegisteredTimer("synapse/load/latency", nil)
+
+	// inflightGauge counts the inferences requested and not answered yet,
+	// queueDepthGauge those of them waiting for a worker.
+	inflightGauge   = metrics.NewRegisteredGauge("synapse/infer/inflight", nil)
+	queueDepthGauge = metrics.NewRegisteredGauge("synapse/queue/depth", nil)
+
+	resultCacheHitCounter  = metrics.NewRegisteredCounter("synapse/resultcache/hit", nil)
+	resultCacheMissCounter = metrics.NewRegisteredCounter("synapse/resultcache/miss", nil)
+
+	// Models completed by the storage: checked, failing the check, and
+	// loaded ahead of their first inference.
+	completedCheckCounter   = metrics.NewRegisteredCounter("synapse/completed/check", nil)
+	completedInvalidCounter = metrics.NewRegisteredCounter("synapse/completed/invalid", nil)
+	completedWarmCounter    = metrics.NewRegisteredCounter("synapse/completed/warm", nil)
+)
+
+// modelTimer returns the latency timer of one model for an operation, load
+// or infer, registered on first use.
+func modelTimer(modelHash, op string) metrics.Timer {
+	if !metrics.Enabled {
+		return metrics.NilTimer{}
+	}
+	return metrics.GetOrRegisterTimer("synapse/model/"+modelHash+"/"+op, nil)
+}
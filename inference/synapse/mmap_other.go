@@ -0,0 +1,9 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package synapse
+
+// mapFile is unavailable without mmap, inputs are read through the copying
+// path instead.
+func mapFile(path string) ([]byte, func(), error) {
+	return nil, nil, errNotMappable
+}
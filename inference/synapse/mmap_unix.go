@@ -0,0 +1,32 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package synapse
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps a file copy-on-write, so its contents can be decoded in place
+// without writing through to the storage. Files below mapThreshold aren't
+// worth a mapping and are left to the copying path.
+func mapFile(path string) ([]byte, func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	size := info.Size()
+	if size < mapThreshold || size != int64(int(size)) {
+		return nil, nil, errNotMappable
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() { syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,174 @@
+package synapse
+
+import (
+	"strings"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	ONNX_PATH string = "/data/model.onnx"
+
+	ONNX_PLUGIN_PREFIX string = "onnx_"
+)
+
+// offchainSuffix marks the variant of a model run for inferences requested
+// over RPC: quantized on the precisions of its calibration table, with the
+// operators the runtime lacks lowered by the registered fallbacks. Both change
+// the outputs of a model, no fork activates calibration and fallbacks are
+// local to the node, so transactions never run this variant.
+const offchainSuffix = "+offchain"
+
+// onnxSuffix marks the variant of a model inferred from the ONNX fork on,
+// which may resolve to a model published in onnx format. Without it a model
+// lacking a symbol graph is missing, as it always was.
+const onnxSuffix = "+onnx"
+
+// modelVariant is how a model is run, as encoded in its key.
+type modelVariant struct {
+	offchain bool // requested over RPC, see offchainSuffix
+	onnx     bool // onnx models accepted, see onnxSuffix
+}
+
+// ONNXModel returns the key inferring a model from the ONNX fork on.
+func ONNXModel(modelInfoHash string) string {
+	return modelInfoHash + onnxSuffix
+}
+
+// splitModelKey returns the hash of the model a cache key refers to, and
+// the variant the key runs.
+func splitModelKey(key string) (string, modelVariant) {
+	var v modelVariant
+	for {
+		switch {
+		case strings.HasSuffix(key, offchainSuffix):
+			key, v.offchain = strings.TrimSuffix(key, offchainSuffix), true
+		case strings.HasSuffix(key, onnxSuffix):
+			key, v.onnx = strings.TrimSuffix(key, onnxSuffix), true
+		default:
+			return key, v
+		}
+	}
+}
+
+// ModelFormat is the serialization of a model published on chain.
+type ModelFormat int
+
+const (
+	FormatCVM  ModelFormat = iota // symbol json and params binary
+	FormatONNX                    // single ONNX protobuf
+)
+
+func (f ModelFormat) String() string {
+	switch f {
+	case FormatCVM:
+		return "cvm"
+	case FormatONNX:
+		return "onnx"
+	}
+	return "unknown"
+}
+
+// onnxConfig is passed to the onnx plugin in place of the symbol json; the
+// plugin converts the graph at load time and then serves the cvm api.
+var onnxConfig = []byte(`{"format":"onnx"}`)
+
+// modelFiles is the payload of a model torrent.
+type modelFiles struct {
+	format     ModelFormat
+	symbol     []byte
+	params     []byte
+	calibrated bool // symbol rewritten by an int8 calibration table
+}
+
+// maxONNXIRVersion is the newest onnx ir version accepted.
+const maxONNXIRVersion = 10
+
+// onnxModelFields are the wire types of the ModelProto fields.
+var onnxModelFields = map[protowire.Number]protowire.Type{
+	1:  protowire.VarintType, // ir_version
+	2:  protowire.BytesType,  // producer_name
+	3:  protowire.BytesType,  // producer_version
+	4:  protowire.BytesType,  // domain
+	5:  protowire.VarintType, // model_version
+	6:  protowire.BytesType,  // doc_string
+	7:  protowire.BytesType,  // graph
+	8:  protowire.BytesType,  // opset_import
+	14: protowire.BytesType,  // metadata_props
+	20: protowire.BytesType,  // training_info
+	25: protowire.BytesType,  // functions
+}
+
+// isONNX reports whether data is a serialized onnx ModelProto: a sequence of
+// ModelProto fields of the right wire types, spanning all of data, with a
+// known ir_version and a graph.
+func isONNX(data []byte) bool {
+	var (
+		irVersion uint64
+		graph     bool
+	)
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return false
+		}
+		data = data[n:]
+		if want, ok := onnxModelFields[num]; !ok || typ != want {
+			return false
+		}
+		if num == 1 {
+			if irVersion, n = protowire.ConsumeVarint(data); n < 0 {
+				return false
+			}
+		} else if n = protowire.ConsumeFieldValue(num, typ, data); n < 0 {
+			return false
+		}
+		graph = graph || num == 7
+		data = data[n:]
+	}
+	return irVersion >= 1 && irVersion <= maxONNXIRVersion && graph
+}
+
+// readModel reads the payload of a model, detecting its format from the
+// files present in the torrent. The off-chain variant of a model is
+// calibrated with the table shipped with it, if any, and lowered through the
+// operator fallbacks.
+func (s *Synapse) readModel(key string) (*modelFiles, error) {
+	modelHash, variant := splitModelKey(key)
+	modelJson, modelJson_err := s.ReadFile(s.ctx, TorrentURI(modelHash, SYMBOL_PATH))
+	if modelJson_err == nil && modelJson != nil {
+		modelParams, modelParams_err := s.ReadFile(s.ctx, TorrentURI(modelHash, PARAM_PATH))
+		if modelParams_err != nil || modelParams == nil {
+			log.Warn("inferByInputContent: params loaded failed", "model hash", modelHash, "error", modelParams_err)
+			return nil, ErrModelMissing
+		}
+		files := &modelFiles{format: FormatCVM, symbol: modelJson, params: modelParams}
+		// Transactions always run the graph as published
+		if !variant.offchain {
+			return files, nil
+		}
+		if table, ok := s.readCalibration(modelHash); ok {
+			symbol, err := applyCalibration(modelJson, table)
+			if err != nil {
+				log.Warn("inferByInputContent: calibration failed", "model hash", modelHash, "error", err)
+				return nil, ErrModelMalformed
+			}
+			files.symbol, files.calibrated = symbol, true
+		}
+		if err := lowerOperators(modelHash, files); err != nil {
+			return nil, err
+		}
+		return files, nil
+	}
+	if !variant.onnx {
+		log.Warn("inferByInputContent: model loaded failed", "model hash", modelHash, "error", modelJson_err)
+		return nil, ErrModelMissing
+	}
+	onnx, onnx_err := s.ReadFile(s.ctx, TorrentURI(modelHash, ONNX_PATH))
+	if onnx_err == nil && isONNX(onnx) {
+		return &modelFiles{format: FormatONNX, symbol: onnxConfig, params: onnx}, nil
+	}
+	log.Warn("inferByInputContent: model loaded failed", "model hash", modelHash, "error", modelJson_err, "onnx", onnx_err)
+	return nil, ErrModelMissing
+}
@@ -0,0 +1,104 @@
+package synapse
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// testONNX returns a minimal onnx ModelProto of the given ir version.
+func testONNX(irVersion uint64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, irVersion)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, "pytorch")
+	b = protowire.AppendTag(b, 7, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte{0x0a, 0x00})
+	b = protowire.AppendTag(b, 8, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte{0x10, 0x0d})
+	return b
+}
+
+func TestIsONNX(t *testing.T) {
+	model := testONNX(7)
+	tests := []struct {
+		data []byte
+		ok   bool
+	}{
+		{model, true},
+		{testONNX(0), false},
+		{testONNX(maxONNXIRVersion + 1), false},
+		{model[:len(model)-1], false},
+		{append(append([]byte{}, model...), 0x08), false},
+		{model[:len(model)-8], false}, // no graph nor opset_import
+		{[]byte{0x08, 0x07}, false},
+		{[]byte(`{"nodes": []}`), false},
+		// the leading ir_version alone is no onnx model
+		{append([]byte{0x08, 0x07, 0x18}, model...), false},
+		{nil, false},
+	}
+	for i, tt := range tests {
+		if ok := isONNX(tt.data); ok != tt.ok {
+			t.Errorf("test %d: isONNX = %v, want %v", i, ok, tt.ok)
+		}
+	}
+}
+
+func TestSplitModelKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		hash string
+		v    modelVariant
+	}{
+		{"aa", "aa", modelVariant{}},
+		{ONNXModel("aa"), "aa", modelVariant{onnx: true}},
+		{"aa" + offchainSuffix, "aa", modelVariant{offchain: true}},
+		{ONNXModel("aa") + offchainSuffix, "aa", modelVariant{offchain: true, onnx: true}},
+	}
+	for _, tt := range tests {
+		if hash, v := splitModelKey(tt.key); hash != tt.hash || v != tt.v {
+			t.Errorf("splitModelKey(%q) = %q, %+v, want %q, %+v", tt.key, hash, v, tt.hash, tt.v)
+		}
+	}
+}
+
+func TestReadModelONNXFork(t *testing.T) {
+	root, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(root, "aa", "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "aa", "data", "model.onnx"), testONNX(7), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Synapse{config: &Config{}}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	defer s.cancel()
+	s.RegisterSource("torrent", NewDirSource(root))
+
+	// Before the fork a model without symbol graph is missing
+	if _, err := s.readModel("aa"); err != ErrModelMissing {
+		t.Fatalf("onnx model read before the fork: %v", err)
+	}
+	if s.modelDownloaded("aa") {
+		t.Errorf("onnx model downloaded before the fork")
+	}
+	files, err := s.readModel(ONNXModel("aa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files.format != FormatONNX {
+		t.Errorf("format %v, want onnx", files.format)
+	}
+	if !s.modelDownloaded(ONNXModel("aa")) {
+		t.Errorf("onnx model not downloaded after the fork")
+	}
+}
@@ -0,0 +1,195 @@
+package synapse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+)
+
+// nativeOps are the operators implemented by the cvm runtime, looked up by
+// opName. The runtime only has integer kernels, so these are the
+// deterministic operators.
+var nativeOps = deterministicOps
+
+// UnsupportedOperatorError is returned when the off-chain variant of a model
+// uses operators neither the runtime nor a registered fallback implements. It
+// unwraps to KERNEL_RUNTIME_ERROR, the error a failed model load has always
+// reported.
+type UnsupportedOperatorError struct {
+	Model string
+	Ops   []string
+}
+
+func (e *UnsupportedOperatorError) Error() string {
+	return fmt.Sprintf("model %s uses unsupported operators: %s", e.Model, strings.Join(e.Ops, ", "))
+}
+
+func (e *UnsupportedOperatorError) Unwrap() error {
+	return KERNEL_RUNTIME_ERROR
+}
+
+func (e *UnsupportedOperatorError) ErrorCode() ErrorCode {
+	return CodeOpUnsupported
+}
+
+// FallbackNode is a graph node handed to an operator fallback. Fallbacks are
+// registered per node, so they only lower the off-chain variant of models
+// inferred over RPC, never a model run by a transaction.
+type FallbackNode struct {
+	Name  string                 // node name
+	Op    string                 // operator, a fallback replaces it by a native one
+	Attrs map[string]interface{} // operator attributes, func_name excluded
+}
+
+// OperatorFallback lowers a node of an operator the runtime lacks to an
+// equivalent native operator, rewriting its operator and attributes in
+// place.
+type OperatorFallback func(node *FallbackNode) error
+
+var (
+	fallbackLock sync.RWMutex
+	fallbacks    = make(map[string]OperatorFallback)
+)
+
+// RegisterOperatorFallback plugs in a fallback for an operator the runtime
+// doesn't implement. Native operators can't be overridden.
+func RegisterOperatorFallback(op string, fb OperatorFallback) error {
+	if nativeOps[opName(op)] {
+		return fmt.Errorf("operator %q is native", op)
+	}
+	fallbackLock.Lock()
+	defer fallbackLock.Unlock()
+	if _, ok := fallbacks[op]; ok {
+		return fmt.Errorf("operator %q already has a fallback", op)
+	}
+	fallbacks[op] = fb
+	return nil
+}
+
+// OperatorFallbacks returns the operators with a registered fallback.
+func OperatorFallbacks() []string {
+	fallbackLock.RLock()
+	defer fallbackLock.RUnlock()
+	ops := make([]string, 0, len(fallbacks))
+	for op := range fallbacks {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+func operatorFallback(op string) (OperatorFallback, bool) {
+	fallbackLock.RLock()
+	defer fallbackLock.RUnlock()
+	fb, ok := fallbacks[op]
+	return fb, ok
+}
+
+// unsupportedOps returns the operators of a cvm graph the runtime doesn't
+// implement. Models are never refused on it when loaded, the runtime itself
+// being the authority on what it executes; it only informs model checks.
+func unsupportedOps(symbol []byte) ([]string, error) {
+	var graph struct {
+		Nodes []graphNode `json:"nodes"`
+	}
+	if err := json.Unmarshal(symbol, &graph); err != nil {
+		return nil, fmt.Errorf("invalid symbol: %v", err)
+	}
+	missing := make(map[string]bool)
+	for _, node := range graph.Nodes {
+		switch node.Op {
+		case "null":
+		case "cvm_op":
+			if op := opName(node.Attrs.FuncName); !nativeOps[op] {
+				missing[op] = true
+			}
+		default:
+			missing[node.Op] = true
+		}
+	}
+	ops := make([]string, 0, len(missing))
+	for op := range missing {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	return ops, nil
+}
+
+// lowerOperators rewrites the operators of a cvm model the runtime lacks
+// through their fallbacks, failing if one has none. It only runs for the
+// off-chain variant of a model. Other formats are converted by their plugin
+// and aren't checked.
+func lowerOperators(modelHash string, files *modelFiles) error {
+	if files.format != FormatCVM {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(files.symbol))
+	dec.UseNumber()
+	var graph map[string]interface{}
+	if err := dec.Decode(&graph); err != nil {
+		return fmt.Errorf("%w: invalid symbol: %v", KERNEL_RUNTIME_ERROR, err)
+	}
+	nodes, _ := graph["nodes"].([]interface{})
+
+	missing := make(map[string]bool)
+	lowered := 0
+	for _, n := range nodes {
+		node, _ := n.(map[string]interface{})
+		switch kind, _ := node["op"].(string); kind {
+		case "null":
+			continue
+		case "cvm_op":
+		default:
+			missing[kind] = true
+			continue
+		}
+		attrs, _ := node["attrs"].(map[string]interface{})
+		funcName, _ := attrs["func_name"].(string)
+		op := opName(funcName)
+		if nativeOps[op] {
+			continue
+		}
+		fb, ok := operatorFallback(op)
+		if !ok {
+			missing[op] = true
+			continue
+		}
+		name, _ := node["name"].(string)
+		fn := &FallbackNode{Name: name, Op: op, Attrs: make(map[string]interface{}, len(attrs))}
+		for k, v := range attrs {
+			if k != "func_name" {
+				fn.Attrs[k] = v
+			}
+		}
+		if err := fb(fn); err != nil || !nativeOps[opName(fn.Op)] {
+			log.Debug("Operator fallback failed", "model hash", modelHash, "node", name, "op", op, "lowered", fn.Op, "err", err)
+			missing[op] = true
+			continue
+		}
+		fn.Attrs["func_name"] = fn.Op
+		node["attrs"] = fn.Attrs
+		lowered++
+	}
+	if len(missing) > 0 {
+		err := &UnsupportedOperatorError{Model: modelHash}
+		for op := range missing {
+			err.Ops = append(err.Ops, op)
+		}
+		sort.Strings(err.Ops)
+		return err
+	}
+	if lowered > 0 {
+		symbol, err := json.Marshal(graph)
+		if err != nil {
+			return err
+		}
+		files.symbol = symbol
+		log.Info("Model operators lowered by fallbacks", "model hash", modelHash, "nodes", lowered)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package synapse
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnsupportedOps(t *testing.T) {
+	symbol := []byte(`{"nodes": [
+		{"op":"null","name":"data","inputs":[]},
+		{"op":"cvm_op","name":"fc","inputs":[[0,0,0]],"attrs":{"func_name":"dense_0"}},
+		{"op":"cvm_op","name":"act","inputs":[[1,0,0]],"attrs":{"func_name":"nn.relu"}},
+		{"op":"cvm_op","name":"nms","inputs":[[2,0,0]],"attrs":{"func_name":"vision.non_max_suppression_1"}},
+		{"op":"cvm_op","name":"out","inputs":[[3,0,0]],"attrs":{"func_name":"softmax_2"}},
+		{"op":"tvm_op","name":"exp","inputs":[[4,0,0]],"attrs":{"func_name":"exp"}}
+	]}`)
+	ops, err := unsupportedOps(symbol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"softmax", "tvm_op"}; !reflect.DeepEqual(ops, want) {
+		t.Errorf("unsupported ops = %v, want %v", ops, want)
+	}
+}
+
+func TestLowerOperators(t *testing.T) {
+	symbol := []byte(`{"nodes": [
+		{"op":"null","name":"data","inputs":[]},
+		{"op":"cvm_op","name":"fc","inputs":[[0,0,0]],"attrs":{"func_name":"dense","units":"10"}},
+		{"op":"cvm_op","name":"act","inputs":[[1,0,0]],"attrs":{"func_name":"test_relu6"}},
+		{"op":"cvm_op","name":"out","inputs":[[2,0,0]],"attrs":{"func_name":"test_softmax"}}
+	]}`)
+	files := &modelFiles{format: FormatCVM, symbol: symbol}
+	err := lowerOperators("0xaa", files)
+	var opErr *UnsupportedOperatorError
+	if !errors.As(err, &opErr) || !errors.Is(err, KERNEL_RUNTIME_ERROR) {
+		t.Fatalf("err = %v, want UnsupportedOperatorError", err)
+	}
+	if want := []string{"test_relu6", "test_softmax"}; !reflect.DeepEqual(opErr.Ops, want) {
+		t.Fatalf("missing ops = %v, want %v", opErr.Ops, want)
+	}
+
+	lower := func(op string) OperatorFallback {
+		return func(node *FallbackNode) error {
+			node.Op = op
+			node.Attrs["a_min"], node.Attrs["a_max"] = "0", "6"
+			return nil
+		}
+	}
+	if err := RegisterOperatorFallback("test_relu6", lower("clip")); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterOperatorFallback("test_softmax", lower("test_relu6")); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterOperatorFallback("dense", lower("clip")); err == nil {
+		t.Error("native operator overridden")
+	}
+	err = lowerOperators("0xaa", files)
+	if !errors.As(err, &opErr) || !reflect.DeepEqual(opErr.Ops, []string{"test_softmax"}) {
+		t.Fatalf("err = %v, want only the non-native lowering refused", err)
+	}
+
+	files.symbol = []byte(`{"nodes": [
+		{"op":"null","name":"data","inputs":[]},
+		{"op":"cvm_op","name":"act","inputs":[[0,0,0]],"attrs":{"func_name":"test_relu6"}}
+	]}`)
+	if err := lowerOperators("0xaa", files); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(files.symbol), `"func_name":"clip"`) || strings.Contains(string(files.symbol), "test_relu6") {
+		t.Errorf("symbol not lowered: %s", files.symbol)
+	}
+}
@@ -0,0 +1,242 @@
+package synapse
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/event"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/torrentfs"
+)
+
+const (
+	preloadInterval = 3 * time.Second
+	preloadTimeout  = 30 * time.Minute
+
+	completedQueue = 16 // completed models waiting to be checked
+)
+
+// completionSource is implemented by storages notifying about completed
+// torrents.
+type completionSource interface {
+	SubscribeCompleted(ch chan<- torrentfs.TorrentCompleted) event.Subscription
+}
+
+// SubscribeCompleted forwards the completed torrents of the storage, so
+// callers caching ModelAvailable know when to ask again. Storages not
+// reporting completions yield a subscription that never fires.
+func (s *Synapse) SubscribeCompleted(ch chan<- torrentfs.TorrentCompleted) event.Subscription {
+	if src, ok := s.config.Storagefs.(completionSource); ok {
+		return src.SubscribeCompleted(ch)
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		<-quit
+		return nil
+	})
+}
+
+var errRemotePreload = errors.New("model preloading unsupported by remote inference")
+
+// ModelFuture tracks a model preload started by PreloadModel.
+type ModelFuture struct {
+	hash string
+	done chan struct{}
+	err  error
+}
+
+// Hash returns the info hash of the model being preloaded.
+func (f *ModelFuture) Hash() string {
+	return f.hash
+}
+
+// Done is closed once the model is loaded into the cache or preloading failed.
+func (f *ModelFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Ready reports whether the model has been loaded and warmed up.
+func (f *ModelFuture) Ready() bool {
+	select {
+	case <-f.done:
+		return f.err == nil
+	default:
+		return false
+	}
+}
+
+// Wait blocks until preloading finished or ctx is cancelled.
+func (f *ModelFuture) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// PreloadModel asks the storage to prioritize the download of a model, then
+// loads it into the inference cache, warming it up on the way, so the
+// first block referencing the model doesn't pay the cold start. Concurrent
+// calls for the same model share one future.
+func (s *Synapse) PreloadModel(modelInfoHash string) *ModelFuture {
+	f := &ModelFuture{hash: modelInfoHash, done: make(chan struct{})}
+	if len(modelInfoHash) < 2 || !strings.HasPrefix(modelInfoHash, "0x") {
+		f.err = KERNEL_RUNTIME_ERROR
+		close(f.done)
+		return f
+	}
+	modelHash := strings.ToLower(modelInfoHash[2:])
+	if v, ok := s.preloads.Load(modelHash); ok {
+		if prev := v.(*ModelFuture); prev.Ready() || !isDone(prev.done) {
+			return prev
+		}
+	}
+	s.preloads.Store(modelHash, f)
+
+	go s.preload(modelHash, f)
+	return f
+}
+
+// PreloadModelBefore is PreloadModel for a model referenced by a pending
+// inference: the storage is told to have the model complete before the given
+// block, switching its download to streaming piece selection.
+func (s *Synapse) PreloadModelBefore(modelInfoHash string, number uint64) *ModelFuture {
+	if !s.config.IsRemoteInfer && len(modelInfoHash) > 2 {
+		if err := s.config.Storagefs.SetDeadline(s.ctx, modelInfoHash, number); err != nil {
+			log.Debug("Model deadline not set", "hash", modelInfoHash, "number", number, "err", err)
+		}
+	}
+	return s.PreloadModel(modelInfoHash)
+}
+
+func (s *Synapse) preload(modelHash string, f *ModelFuture) {
+	defer close(f.done)
+
+	if s.config.IsRemoteInfer {
+		f.err = errRemotePreload
+		return
+	}
+	if f.err = s.config.Storagefs.Prioritize(s.ctx, modelHash); f.err != nil {
+		log.Warn("Model preload failed", "hash", modelHash, "err", f.err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, preloadTimeout)
+	defer cancel()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for ready := false; !ready; {
+		select {
+		case <-timer.C:
+			ready = s.modelDownloaded(modelHash)
+			timer.Reset(preloadInterval)
+		case <-ctx.Done():
+			f.err = ctx.Err()
+			log.Warn("Model preload aborted", "hash", modelHash, "err", f.err)
+			return
+		}
+	}
+
+	if s.sandbox != nil {
+		// Models are loaded by the sandbox workers, not the node.
+		log.Info("Model downloaded", "hash", modelHash)
+		return
+	}
+	start := time.Now()
+	d, mc, err := s.acquireModel(modelHash)
+	if err != nil {
+		f.err = err
+		return
+	}
+	defer s.releaseModel(d, mc)
+	log.Info("Model preloaded", "hash", modelHash, "size", mc.model.Size(), "elapsed", time.Since(start))
+}
+
+// modelDownloaded reports whether the payload of a model, in any format its
+// key accepts, can be read from the storage.
+func (s *Synapse) modelDownloaded(key string) bool {
+	modelHash, variant := splitModelKey(key)
+	if symbol, err := s.ReadFile(s.ctx, TorrentURI(modelHash, SYMBOL_PATH)); err == nil && symbol != nil {
+		params, err := s.ReadFile(s.ctx, TorrentURI(modelHash, PARAM_PATH))
+		return err == nil && params != nil
+	}
+	if !variant.onnx {
+		return false
+	}
+	onnx, err := s.ReadFile(s.ctx, TorrentURI(modelHash, ONNX_PATH))
+	return err == nil && isONNX(onnx)
+}
+
+// watchCompleted checks the models the storage completes and, with WarmLoad,
+// preloads those fitting the free memory of a device.
+func (s *Synapse) watchCompleted(src completionSource) {
+	ch := make(chan torrentfs.TorrentCompleted, completedQueue)
+	sub := src.SubscribeCompleted(ch)
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case ev := <-ch:
+			if ev.IsModel() {
+				s.modelCompleted(strings.ToLower(ev.InfoHash))
+			}
+		case <-sub.Err():
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// modelCompleted validates a model the storage just completed, so a model
+// unable to load is reported before a block references it, and warm loads
+// it if enabled and there is room for it without evicting another model.
+func (s *Synapse) modelCompleted(modelHash string) {
+	completedCheckCounter.Inc(1)
+	check, err := s.CheckModel(s.ctx, "0x"+modelHash)
+	if err != nil {
+		completedInvalidCounter.Inc(1)
+		log.Warn("Completed model unreadable", "hash", modelHash, "err", err)
+		return
+	}
+	if !check.Ok {
+		completedInvalidCounter.Inc(1)
+		log.Warn("Completed model fails validation", "hash", modelHash, "format", check.Format, "errors", check.Errors)
+		return
+	}
+	if !s.config.WarmLoad {
+		log.Debug("Completed model validated", "hash", modelHash, "format", check.Format, "memory", check.Memory)
+		return
+	}
+	if !s.fitsFreeMemory(int64(check.Memory)) {
+		log.Info("Completed model not warm loaded, devices full", "hash", modelHash, "memory", check.Memory)
+		return
+	}
+	completedWarmCounter.Inc(1)
+	log.Info("Warm loading completed model", "hash", modelHash, "format", check.Format, "memory", check.Memory)
+	s.PreloadModel("0x" + modelHash)
+}
+
+// fitsFreeMemory tells whether a device has size bytes left in its memory
+// budget.
+func (s *Synapse) fitsFreeMemory(size int64) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, d := range s.devices {
+		if d.budget-d.used >= size {
+			return true
+		}
+	}
+	return false
+}
+
+func isDone(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
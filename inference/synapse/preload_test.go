@@ -0,0 +1,100 @@
+package synapse
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/event"
+	"github.com/CortexFoundation/torrentfs"
+)
+
+// completedStorage notifies about completed torrents and records the
+// models prioritized by preloads.
+type completedStorage struct {
+	torrentfs.CortexStorage
+	feed        event.Feed
+	prioritized chan string
+}
+
+func (f *completedStorage) Prioritize(ctx context.Context, infohash string) error {
+	f.prioritized <- infohash
+	return errors.New("not downloading")
+}
+
+func (f *completedStorage) SubscribeCompleted(ch chan<- torrentfs.TorrentCompleted) event.Subscription {
+	return f.feed.Subscribe(ch)
+}
+
+func writeModel(t *testing.T, dir, op string, params int) {
+	symbol := `{
+		"nodes": [
+			{"op":"null","name":"data","inputs":[]},
+			{"op":"null","name":"fc_weight","inputs":[]},
+			{"op":"cvm_op","name":"fc","inputs":[[0,0,0],[1,0,0]],"attrs":{"func_name":"` + op + `"}}
+		],
+		"node_row_ptr": [0, 1, 2, 3],
+		"attrs": {"shape": ["list_shape", [[1, 8], [4, 8], [1, 4]]], "dltype": ["list_str", ["int8", "int8", "int32"]]}
+	}`
+	if err := os.MkdirAll(filepath.Join(dir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "data", "symbol"), []byte(symbol), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "data", "params"), make([]byte, params), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWarmLoadCompleted(t *testing.T) {
+	root, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeModel(t, filepath.Join(root, "aa"), "dense", 32)
+	writeModel(t, filepath.Join(root, "bb"), "test_gelu", 32)
+	writeModel(t, filepath.Join(root, "cc"), "dense", 32)
+
+	storage := &completedStorage{prioritized: make(chan string, 4)}
+	s := &Synapse{
+		config:    &Config{DeviceIds: []int{0}, MaxMemoryUsage: MinMemoryUsage, WarmLoad: true, Storagefs: storage},
+		placement: make(map[string]*device),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	defer s.cancel()
+	s.devices = s.newDevices()
+	s.RegisterSource("torrent", NewDirSource(root))
+	go s.watchCompleted(storage)
+	for storage.feed.Send(torrentfs.TorrentCompleted{InfoHash: "dd", Kind: "images"}) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Only the valid model is loaded, the others aren't models or fail
+	// validation.
+	storage.feed.Send(torrentfs.TorrentCompleted{InfoHash: "BB", Kind: "model"})
+	storage.feed.Send(torrentfs.TorrentCompleted{InfoHash: "aa", Kind: "model"})
+	select {
+	case hash := <-storage.prioritized:
+		if hash != "aa" {
+			t.Fatalf("model %s preloaded, want aa", hash)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("completed model not preloaded")
+	}
+
+	// A model that doesn't fit the free memory is left for its first
+	// inference.
+	s.mutex.Lock()
+	s.devices[0].used = s.devices[0].budget
+	s.mutex.Unlock()
+	s.modelCompleted("cc")
+	if _, ok := s.preloads.Load("cc"); ok {
+		t.Fatal("model preloaded with the devices full")
+	}
+}
@@ -0,0 +1,120 @@
+package synapse
+
+import (
+	"context"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/metrics"
+)
+
+var (
+	// ErrInferTimeout is returned when an inference misses its deadline. It
+	// wraps KERNEL_RUNTIME_ERROR, so the block is treated as not processable
+	// by this node instead of recording a result other nodes might not get.
+	ErrInferTimeout = &InferError{CodeTimeout}
+
+	inferQueueTimer   = metrics.NewRegisteredTimer("synapse/queue/wait", nil)
+	inferTimeoutMeter = metrics.NewRegisteredMeter("synapse/queue/timeout", nil)
+)
+
+// InferResult is the outcome of a queued inference.
+type InferResult struct {
+	Data []byte
+	Err  error
+}
+
+type inferTask struct {
+	ctx    context.Context
+	queued time.Time
+	run    func() ([]byte, error)
+	done   func(*InferResult)
+}
+
+// startWorkers launches the bounded pool executing queued inferences.
+func (s *Synapse) startWorkers() {
+	s.tasks = make(chan *inferTask)
+	for i := 0; i < s.config.Workers; i++ {
+		go s.worker()
+	}
+}
+
+func (s *Synapse) worker() {
+	for {
+		select {
+		case task := <-s.tasks:
+			inferQueueTimer.UpdateSince(task.queued)
+			if task.ctx.Err() != nil {
+				// Expired while waiting for a worker.
+				task.done(&InferResult{nil, ErrInferTimeout})
+				continue
+			}
+			data, err := task.run()
+			task.done(&InferResult{data, err})
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// InferAsync queues an inference and returns the channel its result will be
+// delivered on. An empty inputInfoHash runs the model on inputContent.
+func (s *Synapse) InferAsync(ctx context.Context, modelInfoHash, inputInfoHash string, inputContent []byte) <-chan *InferResult {
+	res := make(chan *InferResult, 1)
+	inflightGauge.Inc(1)
+	done := func(r *InferResult) {
+		inflightGauge.Dec(1)
+		res <- r
+	}
+	if s.config.IsRemoteInfer {
+		// Remote inference has its own http timeout.
+		go func() {
+			var data []byte
+			var err error
+			if inputInfoHash != "" {
+				data, err = s.remoteInferByInfoHash(modelInfoHash, inputInfoHash)
+			} else {
+				data, err = s.remoteInferByInputContent(modelInfoHash, inputContent)
+			}
+			done(&InferResult{data, err})
+		}()
+		return res
+	}
+	run := func() ([]byte, error) {
+		if inputInfoHash != "" {
+			return s.inferByInfoHash(ctx, modelInfoHash, inputInfoHash)
+		}
+		return s.inferByInputContent(ctx, modelInfoHash, inputContent)
+	}
+	go func() {
+		queueDepthGauge.Inc(1)
+		defer queueDepthGauge.Dec(1)
+
+		select {
+		case s.tasks <- &inferTask{ctx: ctx, queued: time.Now(), run: run, done: done}:
+		case <-ctx.Done():
+			done(&InferResult{nil, ErrInferTimeout})
+		}
+	}()
+	return res
+}
+
+// inferQueued runs an inference on the worker pool, giving up once the
+// configured timeout passed. A native call that hangs keeps its worker, but
+// no longer blocks the caller.
+func (s *Synapse) inferQueued(modelInfoHash, inputInfoHash string, inputContent []byte) ([]byte, error) {
+	ctx, cancel := s.ctx, context.CancelFunc(func() {})
+	if s.config.InferTimeout > 0 {
+		ctx, cancel = context.WithTimeout(s.ctx, s.config.InferTimeout)
+	}
+	defer cancel()
+
+	select {
+	case res := <-s.InferAsync(ctx, modelInfoHash, inputInfoHash, inputContent):
+		return res.Data, res.Err
+	case <-ctx.Done():
+		inferTimeoutMeter.Mark(1)
+		log.Warn("Inference timed out", "model", modelInfoHash, "input", inputInfoHash, "timeout", s.config.InferTimeout)
+		return nil, ErrInferTimeout
+	}
+}
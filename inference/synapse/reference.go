@@ -0,0 +1,21 @@
+package synapse
+
+import (
+	"strings"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/log"
+)
+
+// Reference tells the storage a consensus inference of a block used a model,
+// so popular models are kept seeding and cached. Calls outside transactions
+// carry no hash and aren't counted.
+func (s *Synapse) Reference(block uint64, tx common.Hash, modelInfoHash string) {
+	if s.config.Storagefs == nil || tx == (common.Hash{}) {
+		return
+	}
+	model := strings.TrimPrefix(strings.ToLower(modelInfoHash), "0x")
+	if err := s.config.Storagefs.Reference(s.ctx, model, block, tx); err != nil {
+		log.Debug("Model reference not counted", "model", model, "err", err)
+	}
+}
@@ -33,7 +33,7 @@ func (s *Synapse) remoteGasByModelHash(modelInfoHash string) (uint64, error) {
 	return binary.BigEndian.Uint64(retArray), nil
 }
 
-//func (s *Synapse) remoteAvailable(infoHash string, rawSize int64, uri string) error {
+// func (s *Synapse) remoteAvailable(infoHash string, rawSize int64, uri string) error {
 func (s *Synapse) remoteAvailable(infoHash string, rawSize int64) error {
 	inferWork := &inference.AvailableWork{
 		Type:     inference.AVAILABLE_BY_H,
@@ -87,7 +87,7 @@ func (s *Synapse) remoteInferByInputContent(modelInfoHash string, inputContent [
 
 func (s *Synapse) sendRequest(requestBody []byte) ([]byte, error) {
 	/*cacheKey := RLPHashString(requestBody)
-	if v, ok := s.simpleCache.Load(cacheKey); ok && !s.config.IsNotCache {
+	if v, ok := s.simpleCache.Get(cacheKey); ok && s.simpleCache != nil {
 		log.Debug("Infer Succeed via Cache", "result", v.([]byte))
 		return v.([]byte), nil
 	}*/
@@ -116,11 +116,16 @@ func (s *Synapse) sendRequest(requestBody []byte) ([]byte, error) {
 	if res.Info == inference.RES_OK {
 		var data = []byte(res.Data)
 		/*if !s.config.IsNotCache {
-			s.simpleCache.Store(cacheKey, data)
+			s.simpleCache.Add(cacheKey, data)
 		}*/
 		return data, nil
 	}
 	// res.Info == inference.RES_ERROR
+	if len(res.Code) > 0 {
+		err := DecodeError(res.Code)
+		log.Debug("VM inference error", "err", err, "req", requestBody)
+		return nil, err
+	}
 	err_str := string(res.Data)
 
 	if err_str == KERNEL_LOGIC_ERROR.Error() {
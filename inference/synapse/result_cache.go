@@ -0,0 +1,88 @@
+package synapse
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// resultEntry is the journal encoding of one cached inference result.
+type resultEntry struct {
+	Key    string
+	Result []byte
+}
+
+// newResultCache creates the bounded inference result cache, filling it from
+// the journal if one is configured.
+func newResultCache(size int, journal string) *lru.Cache {
+	if size <= 0 {
+		size = DefaultConfig.ResultCacheSize
+	}
+	cache, _ := lru.New(size)
+	if journal == "" {
+		return cache
+	}
+	f, err := os.Open(journal)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("Failed to open inference cache journal", "path", journal, "err", err)
+		}
+		return cache
+	}
+	defer f.Close()
+
+	stream := rlp.NewStream(bufio.NewReader(f), 0)
+	loaded := 0
+	for {
+		var entry resultEntry
+		if err := stream.Decode(&entry); err != nil {
+			if err != io.EOF {
+				log.Warn("Inference cache journal corrupted", "path", journal, "loaded", loaded, "err", err)
+			}
+			break
+		}
+		cache.Add(entry.Key, entry.Result)
+		loaded++
+	}
+	log.Info("Loaded inference cache journal", "path", journal, "entries", loaded)
+	return cache
+}
+
+// saveResultCache writes the cached results to the journal, oldest first so
+// the recency order survives a reload.
+func saveResultCache(cache *lru.Cache, journal string) error {
+	tmp := journal + ".new"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	saved := 0
+	for _, key := range cache.Keys() {
+		v, ok := cache.Peek(key)
+		if !ok {
+			continue
+		}
+		if err := rlp.Encode(w, &resultEntry{key.(string), v.([]byte)}); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		saved++
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	log.Info("Saved inference cache journal", "path", journal, "entries", saved)
+	return os.Rename(tmp, journal)
+}
@@ -0,0 +1,42 @@
+package synapse
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResultCacheJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "results.rlp")
+
+	cache := newResultCache(2, journal)
+	cache.Add("a", []byte{1})
+	cache.Add("b", []byte{2})
+	cache.Add("c", []byte{3})
+	if cache.Contains("a") {
+		t.Fatalf("oldest result not evicted")
+	}
+	if err := saveResultCache(cache, journal); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newResultCache(2, journal)
+	if loaded.Len() != 2 {
+		t.Fatalf("loaded %d results, want 2", loaded.Len())
+	}
+	if v, ok := loaded.Get("c"); !ok || !bytes.Equal(v.([]byte), []byte{3}) {
+		t.Fatalf("result c = %v, want [3]", v)
+	}
+	// "b" must remain the least recently used entry after the reload.
+	loaded.Add("d", []byte{4})
+	if loaded.Contains("b") {
+		t.Fatalf("recency order lost across journal reload")
+	}
+}
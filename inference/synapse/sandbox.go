@@ -0,0 +1,499 @@
+package synapse
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/metrics"
+)
+
+const (
+	defaultSandboxOutput = 1 << 20          // output limit when the config leaves it unset
+	sandboxStartTimeout  = 10 * time.Second // time a new worker has to connect
+	sandboxExitTimeout   = time.Second      // time a worker has to exit after its connection broke
+	sandboxTail          = 4096             // bytes of worker stderr kept to diagnose crashes
+)
+
+var (
+	// errWorkerLost reports a broken connection to a worker, errWorkerCrashed
+	// a worker that died from a crash of its own.
+	errWorkerLost    = errors.New("sandbox worker lost")
+	errWorkerCrashed = errors.New("sandbox worker crashed")
+
+	// sandboxMemoryErrors are printed by the go runtime and the c++ runtime
+	// when an allocation exceeds the memory limit.
+	sandboxMemoryErrors = [][]byte{[]byte("out of memory"), []byte("bad_alloc"), []byte("cannot allocate memory")}
+
+	sandboxSpawnMeter = metrics.NewRegisteredMeter("synapse/sandbox/spawn", nil)
+	sandboxKillMeter  = metrics.NewRegisteredMeter("synapse/sandbox/kill", nil)
+	sandboxCrashMeter = metrics.NewRegisteredMeter("synapse/sandbox/crash", nil)
+)
+
+// sandboxConfig is the handshake a worker process receives before its first
+// inference.
+type sandboxConfig struct {
+	DeviceType     string
+	DeviceId       int
+	Deterministic  bool
+	MaxMemoryUsage int64
+	Backends       []string
+	Memory         int64 // limit the worker applies to itself, 0 if enforced by a cgroup
+	Output         int
+}
+
+// sandboxRequest is sent by the parent, either to run an inference or to
+// answer a file read of the worker.
+type sandboxRequest struct {
+	Model string
+	Input []byte
+
+	File    []byte
+	FileErr string
+}
+
+// sandboxReply is sent by the worker, either to read a file through the
+// parent or with the outcome of an inference.
+type sandboxReply struct {
+	Read string
+
+	Output []byte
+	Err    []byte // encoded inference error, nil on success
+}
+
+// sandbox runs inferences in a pool of worker processes, so a model
+// exhausting the memory, hanging or crashing the native library only takes
+// down its worker. Workers talk to the node over a local socket, are spawned
+// on first use and respawned in the background once they die.
+type sandbox struct {
+	s       *Synapse
+	config  *Config
+	command []string
+	pool    chan *sandboxWorker // idle workers, nil for a slot without process
+}
+
+type sandboxWorker struct {
+	cmd    *exec.Cmd
+	conn   net.Conn
+	enc    *gob.Encoder
+	dec    *gob.Decoder
+	cgroup string
+	stderr *tailWriter   // last output of the worker, to tell crashes apart
+	exited chan struct{} // closed once the process is reaped
+	killed bool          // stopped by the node, not by a crash
+}
+
+func newSandbox(s *Synapse) (*sandbox, error) {
+	config := s.config
+	command := config.SandboxCommand
+	if len(command) == 0 {
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		command = []string{exe, "synapse", "worker"}
+	}
+	if config.SandboxOutput <= 0 {
+		config.SandboxOutput = defaultSandboxOutput
+	}
+	sb := &sandbox{
+		s:       s,
+		config:  config,
+		command: command,
+		pool:    make(chan *sandboxWorker, config.Workers),
+	}
+	for i := 0; i < config.Workers; i++ {
+		sb.pool <- nil
+	}
+	return sb, nil
+}
+
+// spawn starts a worker process, passing it the path of a socket in a
+// private directory, and sends the handshake once it connected.
+func (sb *sandbox) spawn() (*sandboxWorker, error) {
+	dir, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "worker.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+
+	w := &sandboxWorker{
+		cmd:    exec.Command(sb.command[0], append(sb.command[1:], path)...),
+		stderr: new(tailWriter),
+		exited: make(chan struct{}),
+	}
+	w.cmd.Stderr = io.MultiWriter(os.Stderr, w.stderr)
+	w.cmd.SysProcAttr = sandboxProcAttr()
+	if err := w.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		w.cmd.Wait()
+		close(w.exited)
+	}()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	select {
+	case w.conn = <-accepted:
+	case <-w.exited:
+	case <-time.After(sandboxStartTimeout):
+	}
+	if w.conn == nil {
+		w.kill()
+		return nil, errors.New("worker did not connect")
+	}
+	w.enc, w.dec = gob.NewEncoder(w.conn), gob.NewDecoder(w.conn)
+
+	hs := &sandboxConfig{
+		DeviceType:     sb.config.DeviceType,
+		DeviceId:       sb.config.DeviceId,
+		Deterministic:  sb.config.Deterministic,
+		MaxMemoryUsage: sb.config.MaxMemoryUsage,
+		Backends:       sb.config.Backends,
+		Memory:         sb.config.SandboxMemory,
+		Output:         sb.config.SandboxOutput,
+	}
+	if sb.config.SandboxMemory > 0 && sb.config.SandboxCgroup != "" {
+		dir, err := joinCgroup(sb.config.SandboxCgroup, w.cmd.Process.Pid, sb.config.SandboxMemory)
+		if err != nil {
+			log.Warn("Sandbox cgroup unavailable, falling back to rlimit", "cgroup", sb.config.SandboxCgroup, "err", err)
+		} else {
+			w.cgroup, hs.Memory = dir, 0
+		}
+	}
+	if err := w.enc.Encode(hs); err != nil {
+		w.kill()
+		return nil, err
+	}
+	sandboxSpawnMeter.Mark(1)
+	log.Debug("Sandbox worker started", "pid", w.cmd.Process.Pid, "memory", sb.config.SandboxMemory, "cgroup", w.cgroup)
+	return w, nil
+}
+
+// kill stops the process of a worker and releases its cgroup.
+func (w *sandboxWorker) kill() {
+	w.killed = true
+	if w.conn != nil {
+		w.conn.Close()
+	}
+	w.cmd.Process.Kill()
+	<-w.exited
+	if w.cgroup != "" {
+		leaveCgroup(w.cgroup)
+	}
+}
+
+// crashed waits for a worker whose connection broke to exit, and reports
+// whether it died on its own for a reason other than running out of memory.
+// Memory limits differ between nodes, a fault of the native library on the
+// same model and input doesn't.
+func (w *sandboxWorker) crashed() bool {
+	select {
+	case <-w.exited:
+	case <-time.After(sandboxExitTimeout):
+		return false
+	}
+	if w.killed || w.cmd.ProcessState.Success() {
+		return false
+	}
+	if status, ok := w.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGKILL {
+		// The kernel out of memory killer, or an operator.
+		return false
+	}
+	return !w.stderr.contains(sandboxMemoryErrors)
+}
+
+// infer runs an inference on an idle worker. A worker that dies or runs past
+// the deadline of ctx is killed and respawned. A crash is retried once on a
+// fresh worker, if that one crashes as well the inference fails. Crashes of
+// native code depend on the build and the machine, the failure is local to
+// the node.
+func (sb *sandbox) infer(ctx context.Context, modelInfoHash string, input []byte) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		output, err := sb.run(ctx, modelInfoHash, input)
+		if err != errWorkerCrashed {
+			return output, err
+		}
+		if attempt > 0 {
+			log.Warn("Model crashed the sandbox twice", "model", modelInfoHash)
+			return nil, ErrWorkerCrashed
+		}
+	}
+}
+
+func (sb *sandbox) run(ctx context.Context, modelInfoHash string, input []byte) ([]byte, error) {
+	var w *sandboxWorker
+	select {
+	case w = <-sb.pool:
+	case <-ctx.Done():
+		return nil, ErrInferTimeout
+	}
+	if w == nil {
+		var err error
+		if w, err = sb.spawn(); err != nil {
+			sb.pool <- nil
+			log.Error("Failed to start sandbox worker", "command", sb.command, "err", err)
+			return nil, ErrSandbox
+		}
+	}
+	done := make(chan *InferResult, 1)
+	go func() {
+		output, err := sb.exchange(ctx, w, modelInfoHash, input)
+		done <- &InferResult{output, err}
+	}()
+	select {
+	case res := <-done:
+		switch res.Err {
+		case errWorkerLost:
+			if w.crashed() {
+				sandboxCrashMeter.Mark(1)
+				log.Warn("Sandbox worker crashed", "pid", w.cmd.Process.Pid, "model", modelInfoHash, "status", w.cmd.ProcessState, "stderr", w.stderr.String())
+				sb.release(w, true)
+				return nil, errWorkerCrashed
+			}
+			log.Warn("Sandbox worker lost", "pid", w.cmd.Process.Pid, "model", modelInfoHash)
+			sb.release(w, true)
+			return nil, ErrSandbox
+		case ErrSandbox:
+			log.Warn("Sandbox worker exceeded its limits", "pid", w.cmd.Process.Pid, "model", modelInfoHash)
+			sb.release(w, true)
+			return nil, ErrSandbox
+		}
+		sb.release(w, false)
+		return res.Data, res.Err
+	case <-ctx.Done():
+		log.Warn("Sandbox worker killed at deadline", "pid", w.cmd.Process.Pid, "model", modelInfoHash)
+		sb.release(w, true)
+		<-done
+		return nil, ErrInferTimeout
+	}
+}
+
+// release returns a worker to the pool. A failed one is killed and replaced
+// in the background, unless the engine is shutting down.
+func (sb *sandbox) release(w *sandboxWorker, failed bool) {
+	if sb.s.ctx.Err() != nil {
+		w.kill()
+		sb.pool <- nil
+		return
+	}
+	if !failed {
+		sb.pool <- w
+		return
+	}
+	sandboxKillMeter.Mark(1)
+	w.kill()
+	go sb.respawn()
+}
+
+// respawn fills a slot whose worker died. A worker that fails to start
+// leaves the slot empty, the next inference retries.
+func (sb *sandbox) respawn() {
+	w, err := sb.spawn()
+	if err != nil {
+		log.Warn("Failed to respawn sandbox worker", "err", err)
+		w = nil
+	} else if sb.s.ctx.Err() != nil {
+		w.kill()
+		w = nil
+	}
+	sb.pool <- w
+}
+
+// exchange sends an inference to a worker and serves its file reads until
+// the outcome arrives. A broken connection reports errWorkerLost, an
+// oversized output ErrSandbox.
+func (sb *sandbox) exchange(ctx context.Context, w *sandboxWorker, modelInfoHash string, input []byte) ([]byte, error) {
+	if err := w.enc.Encode(&sandboxRequest{Model: modelInfoHash, Input: input}); err != nil {
+		return nil, errWorkerLost
+	}
+	for {
+		var reply sandboxReply
+		if err := w.dec.Decode(&reply); err != nil {
+			return nil, errWorkerLost
+		}
+		if reply.Read == "" {
+			if reply.Err != nil {
+				return nil, DecodeError(reply.Err)
+			}
+			if len(reply.Output) > sb.config.SandboxOutput {
+				return nil, ErrSandbox
+			}
+			return reply.Output, nil
+		}
+		var req sandboxRequest
+		if u, err := url.Parse(reply.Read); err != nil || u.Scheme != "torrent" {
+			req.FileErr = "file not served to the sandbox"
+		} else if req.File, err = sb.s.ReadFile(ctx, reply.Read); err != nil {
+			req.FileErr = err.Error()
+		}
+		if err := w.enc.Encode(&req); err != nil {
+			return nil, errWorkerLost
+		}
+	}
+}
+
+// close kills the idle workers, busy ones are killed once released.
+func (sb *sandbox) close() {
+	var idle int
+	for done := false; !done; {
+		select {
+		case w := <-sb.pool:
+			if w != nil {
+				w.kill()
+			}
+			idle++
+		default:
+			done = true
+		}
+	}
+	for i := 0; i < idle; i++ {
+		sb.pool <- nil
+	}
+}
+
+// tailWriter keeps the last bytes written to it.
+type tailWriter struct {
+	lock sync.Mutex
+	buf  []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > sandboxTail {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-sandboxTail:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailWriter) contains(patterns [][]byte) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, p := range patterns {
+		if bytes.Contains(t.buf, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tailWriter) String() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return string(t.buf)
+}
+
+// pipeSource reads torrent files of a worker through its parent.
+type pipeSource struct {
+	enc *gob.Encoder
+	dec *gob.Decoder
+}
+
+func (p *pipeSource) ReadFile(ctx context.Context, uri *url.URL) ([]byte, error) {
+	if err := p.enc.Encode(&sandboxReply{Read: uri.String()}); err != nil {
+		return nil, err
+	}
+	var req sandboxRequest
+	if err := p.dec.Decode(&req); err != nil {
+		return nil, err
+	}
+	if req.FileErr != "" {
+		return nil, errors.New(req.FileErr)
+	}
+	return req.File, nil
+}
+
+// ServeSandboxSocket runs a worker process connecting to its parent over
+// the local socket at path.
+func ServeSandboxSocket(path string) error {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return ServeSandbox(conn, conn)
+}
+
+// ServeSandbox runs the inferences a sandbox parent sends over in, writing
+// the outcomes to out, until in is closed. It is the body of a worker
+// process and must not share the process with another engine.
+func ServeSandbox(in io.Reader, out io.Writer) error {
+	var (
+		enc = gob.NewEncoder(out)
+		dec = gob.NewDecoder(in)
+		hs  sandboxConfig
+	)
+	if err := dec.Decode(&hs); err != nil {
+		return err
+	}
+	if hs.Memory > 0 {
+		if err := limitMemory(hs.Memory); err != nil {
+			log.Warn("Sandbox memory limit not applied", "limit", hs.Memory, "err", err)
+		}
+	}
+	s := New(&Config{
+		IsNotCache:     true,
+		DeviceType:     hs.DeviceType,
+		DeviceId:       hs.DeviceId,
+		Deterministic:  hs.Deterministic,
+		MaxMemoryUsage: hs.MaxMemoryUsage,
+		Backends:       hs.Backends,
+		Workers:        1,
+	})
+	if s == nil {
+		return errors.New("failed to load the inference runtime")
+	}
+	defer s.Close()
+	s.RegisterSource("torrent", &pipeSource{enc, dec})
+
+	for {
+		var req sandboxRequest
+		if err := dec.Decode(&req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if req.Input == nil {
+			// gob drops empty slices, which would read as a missing input.
+			req.Input = []byte{}
+		}
+		reply := new(sandboxReply)
+		output, err := s.infer(s.ctx, req.Model, "", req.Input)
+		if err == nil && hs.Output > 0 && len(output) > hs.Output {
+			err = ErrSandbox
+		}
+		if err != nil {
+			reply.Err = EncodeError(err)
+		} else {
+			reply.Output = output
+		}
+		if err := enc.Encode(reply); err != nil {
+			return err
+		}
+	}
+}
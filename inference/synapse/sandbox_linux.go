@@ -0,0 +1,49 @@
+// +build linux
+
+package synapse
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// sandboxProcAttr kills a worker along with the node.
+func sandboxProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+}
+
+// joinCgroup creates a child of the delegated cgroup v2 directory root
+// limited to memory bytes and moves the process into it.
+func joinCgroup(root string, pid int, memory int64) (string, error) {
+	dir := filepath.Join(root, fmt.Sprintf("synapse-worker-%d", pid))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatInt(memory, 10)), 0644); err != nil {
+		os.Remove(dir)
+		return "", err
+	}
+	// Without swap accounting the file is missing, the limit still holds.
+	ioutil.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0644)
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		os.Remove(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// leaveCgroup removes the cgroup of a worker that exited.
+func leaveCgroup(dir string) {
+	os.Remove(dir)
+}
+
+// limitMemory caps the data segment of the current process, the fallback
+// when no cgroup is delegated to the node.
+func limitMemory(limit int64) error {
+	rlim := &syscall.Rlimit{Cur: uint64(limit), Max: uint64(limit)}
+	return syscall.Setrlimit(syscall.RLIMIT_DATA, rlim)
+}
@@ -0,0 +1,24 @@
+// +build !linux
+
+package synapse
+
+import (
+	"errors"
+	"syscall"
+)
+
+var errSandboxLimits = errors.New("sandbox memory limits are only supported on linux")
+
+func sandboxProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+func joinCgroup(root string, pid int, memory int64) (string, error) {
+	return "", errSandboxLimits
+}
+
+func leaveCgroup(dir string) {}
+
+func limitMemory(limit int64) error {
+	return errSandboxLimits
+}
@@ -0,0 +1,162 @@
+package synapse
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSandboxExchange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "ab"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "ab", "data"), []byte("input"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Synapse{config: &Config{SandboxOutput: 8}, ctx: context.Background()}
+	s.RegisterSource("torrent", NewDirSource(dir))
+	sb := &sandbox{s: s, config: s.config}
+
+	// Fake worker reading a torrent file and a local one through the parent,
+	// then answering with what it got.
+	parentIn, workerOut := io.Pipe()
+	workerIn, parentOut := io.Pipe()
+	go func() {
+		enc, dec := gob.NewEncoder(workerOut), gob.NewDecoder(workerIn)
+		src := &pipeSource{enc, dec}
+		for {
+			var req sandboxRequest
+			if dec.Decode(&req) != nil {
+				return
+			}
+			file, err := src.ReadFile(context.Background(), &url.URL{Scheme: "torrent", Host: "ab", Path: "/data"})
+			if err != nil {
+				enc.Encode(&sandboxReply{Err: EncodeError(ErrInputMissing)})
+				continue
+			}
+			// Only torrent files are served to workers.
+			if _, err := src.ReadFile(context.Background(), &url.URL{Scheme: "file", Path: dir}); err == nil {
+				enc.Encode(&sandboxReply{Err: EncodeError(KERNEL_LOGIC_ERROR)})
+				continue
+			}
+			enc.Encode(&sandboxReply{Output: append(file, req.Input...)})
+		}
+	}()
+	w := &sandboxWorker{enc: gob.NewEncoder(parentOut), dec: gob.NewDecoder(parentIn)}
+
+	output, err := sb.exchange(context.Background(), w, "0x00", []byte("!"))
+	if err != nil || !bytes.Equal(output, []byte("input!")) {
+		t.Fatalf("exchange = %q, %v, want %q", output, err, "input!")
+	}
+	// Outputs past the limit fail the worker.
+	if _, err := sb.exchange(context.Background(), w, "0x00", []byte("1234")); err != ErrSandbox {
+		t.Fatalf("oversized output error mismatch: have %v, want %v", err, ErrSandbox)
+	}
+}
+
+// TestSandboxHelper is the worker process of the sandbox tests, behaving as
+// told by SYNAPSE_SANDBOX_HELPER.
+func TestSandboxHelper(t *testing.T) {
+	mode := os.Getenv("SYNAPSE_SANDBOX_HELPER")
+	if mode == "" {
+		t.Skip("sandbox worker helper")
+	}
+	conn, err := net.Dial("unix", os.Args[len(os.Args)-1])
+	if err != nil {
+		os.Exit(1)
+	}
+	enc, dec := gob.NewEncoder(conn), gob.NewDecoder(conn)
+	var hs sandboxConfig
+	if dec.Decode(&hs) != nil {
+		os.Exit(1)
+	}
+	for {
+		var req sandboxRequest
+		if dec.Decode(&req) != nil {
+			os.Exit(0)
+		}
+		switch mode {
+		case "echo":
+			enc.Encode(&sandboxReply{Output: req.Input})
+		case "crash":
+			fmt.Fprintln(os.Stderr, "fatal error: unexpected signal during runtime execution")
+			os.Exit(2)
+		case "oom":
+			fmt.Fprintln(os.Stderr, "fatal error: runtime: out of memory")
+			os.Exit(2)
+		case "hang":
+			time.Sleep(time.Minute)
+		}
+	}
+}
+
+func newTestSandbox(t *testing.T, mode string) (*sandbox, func()) {
+	os.Setenv("SYNAPSE_SANDBOX_HELPER", mode)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Synapse{
+		config: &Config{Workers: 1, SandboxCommand: []string{os.Args[0], "-test.run=^TestSandboxHelper$"}},
+		ctx:    ctx,
+	}
+	sb, err := newSandbox(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sb, func() {
+		cancel()
+		for i := 0; i < cap(sb.pool); i++ {
+			if w := <-sb.pool; w != nil {
+				w.kill()
+			}
+		}
+		os.Unsetenv("SYNAPSE_SANDBOX_HELPER")
+	}
+}
+
+func TestSandboxWorkers(t *testing.T) {
+	tests := []struct {
+		mode string
+		want error
+	}{
+		{"echo", nil},
+		// A crash reproduced on a fresh worker fails the inference, as a
+		// local error.
+		{"crash", ErrWorkerCrashed},
+		// Running out of memory depends on the limits of the node.
+		{"oom", ErrSandbox},
+		// A worker hanging past the deadline is killed.
+		{"hang", ErrInferTimeout},
+	}
+	for _, tt := range tests {
+		sb, stop := newTestSandbox(t, tt.mode)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		output, err := sb.infer(ctx, "0x00", []byte("input"))
+		cancel()
+		if err != tt.want {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.mode, err, tt.want)
+		}
+		if err == nil && !bytes.Equal(output, []byte("input")) {
+			t.Errorf("%s: output mismatch: have %q, want %q", tt.mode, output, "input")
+		}
+		// Failed workers are replaced in the background.
+		if w := <-sb.pool; w == nil {
+			t.Errorf("%s: worker not respawned", tt.mode)
+		} else {
+			sb.pool <- w
+		}
+		stop()
+	}
+}
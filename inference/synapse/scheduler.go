@@ -0,0 +1,284 @@
+package synapse
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common/lru"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/metrics"
+)
+
+var (
+	deviceBusyMeter = metrics.NewRegisteredMeter("synapse/scheduler/busy", nil)
+
+	modelCacheHitMeter   = metrics.NewRegisteredMeter("synapse/modelcache/hit", nil)
+	modelCacheMissMeter  = metrics.NewRegisteredMeter("synapse/modelcache/miss", nil)
+	modelCacheEvictMeter = metrics.NewRegisteredMeter("synapse/modelcache/evict", nil)
+)
+
+// device is one inference backend the scheduler can run models on. Every
+// device owns a model cache; inferences of different models run on it in
+// parallel, each model in its own execution context.
+type device struct {
+	hits, misses, evictions uint64 // atomic, kept first for 64-bit alignment
+
+	id     int
+	lock   sync.Mutex // guards the cache and the model contexts in it
+	cache  *lru.Cache
+	budget int64
+
+	// guarded by Synapse.mutex
+	pending int   // inferences queued or running on the device
+	used    int64 // resident size of the models loaded on the device
+	models  int   // number of models loaded on the device
+}
+
+// modelContext is a model loaded on a device. Inferences of the model are
+// serialized by its lock, while other models on the device run concurrently.
+type modelContext struct {
+	model   Model
+	backend *namedBackend
+	lock    sync.Mutex // serializes inference on the model
+
+	// guarded by device.lock
+	refs    int  // inferences holding the model
+	evicted bool // dropped from the cache, freed once the last holder left
+}
+
+// newDevices creates the model caches of all configured devices.
+func (s *Synapse) newDevices() []*device {
+	ids := s.config.DeviceIds
+	if len(ids) == 0 {
+		ids = []int{s.config.DeviceId}
+	}
+	memoryUsage := s.config.MaxMemoryUsage
+	if memoryUsage < MinMemoryUsage {
+		memoryUsage = MinMemoryUsage
+	}
+	memoryUsage -= ReservedMemoryUsage
+
+	devices := make([]*device, 0, len(ids))
+	for _, id := range ids {
+		d := &device{id: id, cache: lru.New(memoryUsage), budget: memoryUsage}
+		d.cache.OnEvicted = func(key lru.Key, value interface{}) {
+			mc := value.(*modelContext)
+			model := mc.model
+			log.Warn("C FREE On Evicted", "k", key, "device", d.id, "size", model.Size(), "max", s.config.MaxMemoryUsage, "min", MinMemoryUsage, "refs", mc.refs)
+			atomic.AddUint64(&d.evictions, 1)
+			modelCacheEvictMeter.Mark(1)
+			s.mutex.Lock()
+			d.used -= int64(model.Size())
+			d.models--
+			if s.placement[key.(string)] == d {
+				delete(s.placement, key.(string))
+			}
+			s.mutex.Unlock()
+			// A model still running is freed by its last holder.
+			if mc.evicted = true; mc.refs == 0 {
+				mc.backend.Free(model)
+			}
+		}
+		devices = append(devices, d)
+	}
+	log.Info("Memory alloc", "size", memoryUsage, "devices", ids)
+	return devices
+}
+
+// acquireDevice picks the device to run a model on and locks it. A device that
+// already holds the model is preferred while its queue is not full, otherwise
+// the least busy device with the most free memory is chosen so a newly loaded
+// model evicts as little as possible.
+func (s *Synapse) acquireDevice(modelHash string) *device {
+	s.mutex.Lock()
+	best := s.placement[modelHash]
+	if best != nil && best.pending >= s.config.DeviceQueue {
+		deviceBusyMeter.Mark(1)
+		best = nil
+	}
+	if best == nil {
+		for _, d := range s.devices {
+			if best == nil || d.pending < best.pending ||
+				(d.pending == best.pending && d.budget-d.used > best.budget-best.used) {
+				best = d
+			}
+		}
+	}
+	best.pending++
+	s.mutex.Unlock()
+
+	best.lock.Lock()
+	return best
+}
+
+// releaseDevice unlocks a device returned by acquireDevice.
+func (s *Synapse) releaseDevice(d *device) {
+	d.lock.Unlock()
+
+	s.mutex.Lock()
+	d.pending--
+	s.mutex.Unlock()
+}
+
+// acquireModel loads a model on a device and locks its execution context.
+// The device itself is only held while the model is looked up or loaded, so
+// inferences of other models on the device proceed in parallel, while calls
+// for the same model are serialized.
+func (s *Synapse) acquireModel(modelHash string) (*device, *modelContext, error) {
+	d := s.acquireDevice(modelHash)
+	mc, err := s.loadModel(d, modelHash)
+	if err != nil {
+		s.releaseDevice(d)
+		return nil, nil, err
+	}
+	mc.refs++
+	d.lock.Unlock()
+
+	mc.lock.Lock()
+	return d, mc, nil
+}
+
+// releaseModel unlocks a model returned by acquireModel, freeing it if it
+// was evicted in the meantime.
+func (s *Synapse) releaseModel(d *device, mc *modelContext) {
+	mc.lock.Unlock()
+
+	d.lock.Lock()
+	if mc.refs--; mc.refs == 0 && mc.evicted {
+		mc.backend.Free(mc.model)
+	}
+	s.releaseDevice(d)
+}
+
+// loadModel returns the model from the cache of the device, reading it from
+// the storage on a cache miss. The caller must have acquired the device.
+func (s *Synapse) loadModel(d *device, modelHash string) (*modelContext, error) {
+	if mc, ok := d.cache.Get(modelHash); ok {
+		atomic.AddUint64(&d.hits, 1)
+		modelCacheHitMeter.Mark(1)
+		return mc.(*modelContext), nil
+	}
+	atomic.AddUint64(&d.misses, 1)
+	modelCacheMissMeter.Mark(1)
+	start := time.Now()
+
+	files, err := s.readModel(modelHash)
+	if err != nil {
+		return nil, err
+	}
+	if s.config.Deterministic {
+		if err := checkDeterministic(files); err != nil {
+			log.Warn("Model refused by deterministic verification", "model hash", modelHash, "err", err)
+			err = &DeterministicError{Model: modelHash, Reason: err}
+			s.unloadable.Store(modelHash, err)
+			return nil, err
+		}
+	}
+	b, err := s.selectBackend(modelHash, files)
+	if err != nil {
+		return nil, err
+	}
+	// Admission control: a model that cannot fit into the device memory
+	// budget would only thrash the cache.
+	if int64(len(files.params)) > d.budget {
+		log.Warn("Model exceeds device memory budget", "model hash", modelHash, "device", d.id, "size", len(files.params), "budget", d.budget)
+		return nil, KERNEL_RUNTIME_ERROR
+	}
+	// Make room before loading, so the new model doesn't push the device
+	// over its budget while the evicted ones are still resident.
+	d.evict(int64(len(files.params)))
+
+	model, err := b.LoadModel(files.format, files.symbol, files.params, s.config.DeviceType, d.id)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("Model loaded", "model hash", modelHash, "backend", b.name, "device", d.id)
+	loadTimer.UpdateSince(start)
+	modelTimer(modelHash, "load").UpdateSince(start)
+	meta := newModelMeta(modelHash, files, model)
+	meta.Backend = b.name
+	s.metas.Store(modelHash, meta)
+
+	s.mutex.Lock()
+	d.used += int64(model.Size())
+	d.models++
+	s.placement[modelHash] = d
+	s.mutex.Unlock()
+
+	mc := &modelContext{model: model, backend: b}
+	d.cache.Add(modelHash, mc, int64(model.Size()))
+	d.evict(0)
+
+	// Warm up in the background, the device isn't held up by the dummy
+	// inference and the model stays resident until it finished.
+	mc.refs++
+	go s.warmUp(d, modelHash, mc)
+	return mc, nil
+}
+
+// evict drops least recently used models until size more bytes fit into the
+// budget of the device. The most recent model is always kept; a model still
+// running stays resident until its inferences finished. The caller must have
+// acquired the device.
+func (d *device) evict(size int64) {
+	for d.cache.Len() > 1 && d.cache.CurrentWeight+size > d.budget {
+		d.cache.RemoveOldest()
+	}
+}
+
+// DeviceCacheStats describes the model cache of one inference device.
+type DeviceCacheStats struct {
+	Device    int    `json:"device"`
+	Models    int    `json:"models"`
+	Used      int64  `json:"used"`
+	Budget    int64  `json:"budget"`
+	Pending   int    `json:"pending"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// CacheStats returns the model cache usage of all devices.
+func (s *Synapse) CacheStats() []DeviceCacheStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := make([]DeviceCacheStats, 0, len(s.devices))
+	for _, d := range s.devices {
+		stats = append(stats, DeviceCacheStats{
+			Device:    d.id,
+			Models:    d.models,
+			Used:      d.used,
+			Budget:    d.budget,
+			Pending:   d.pending,
+			Hits:      atomic.LoadUint64(&d.hits),
+			Misses:    atomic.LoadUint64(&d.misses),
+			Evictions: atomic.LoadUint64(&d.evictions),
+		})
+	}
+	return stats
+}
+
+// EngineStats is the state of the engine shown on the storage dashboard.
+type EngineStats struct {
+	Devices    []DeviceCacheStats `json:"devices"`
+	Results    int                `json:"results"`    // inference results cached
+	Preloading int                `json:"preloading"` // models being preloaded
+}
+
+// EngineStats returns the model caches of the devices along with the result
+// cache and the preloads in progress.
+func (s *Synapse) EngineStats() EngineStats {
+	stats := EngineStats{Devices: s.CacheStats()}
+	if s.simpleCache != nil {
+		stats.Results = s.simpleCache.Len()
+	}
+	s.preloads.Range(func(_, v interface{}) bool {
+		if !isDone(v.(*ModelFuture).done) {
+			stats.Preloading++
+		}
+		return true
+	})
+	return stats
+}
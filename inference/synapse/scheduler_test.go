@@ -0,0 +1,37 @@
+package synapse
+
+import (
+	"testing"
+)
+
+func TestAcquireDevice(t *testing.T) {
+	s := &Synapse{
+		config:    &Config{DeviceIds: []int{0, 1}, DeviceQueue: 2, MaxMemoryUsage: MinMemoryUsage},
+		placement: make(map[string]*device),
+	}
+	s.devices = s.newDevices()
+
+	// A device holding the model is preferred until its queue is full.
+	s.placement["a"] = s.devices[1]
+	s.devices[1].pending = 1
+	if d := s.acquireDevice("a"); d != s.devices[1] {
+		t.Fatalf("model scheduled on device %d, want 1", d.id)
+	} else {
+		s.releaseDevice(d)
+	}
+	s.devices[1].pending = 2
+	if d := s.acquireDevice("a"); d != s.devices[0] {
+		t.Fatalf("model scheduled on busy device %d, want 0", d.id)
+	} else {
+		s.releaseDevice(d)
+	}
+
+	// Among equally busy devices the one with more free memory wins.
+	s.devices[1].pending = 0
+	s.devices[0].used = 1024
+	if d := s.acquireDevice("b"); d != s.devices[1] {
+		t.Fatalf("model scheduled on device %d, want 1", d.id)
+	} else {
+		s.releaseDevice(d)
+	}
+}
@@ -0,0 +1,276 @@
+package synapse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CortexFoundation/torrentfs"
+)
+
+const (
+	maxHTTPFileSize = 1 << 30 // bound of files fetched over http
+	mapThreshold    = 1 << 20 // smallest file mapped rather than read
+)
+
+var (
+	// errNotMappable is returned by sources that can't map a file into memory.
+	errNotMappable = errors.New("file not mappable")
+	// errNoRange is returned by sources that can't read part of a file.
+	errNoRange = errors.New("file ranges unsupported")
+)
+
+// FileSource reads model and input files from one kind of location.
+type FileSource interface {
+	ReadFile(ctx context.Context, uri *url.URL) ([]byte, error)
+}
+
+// FileMapper is implemented by sources able to map files into memory. The
+// mapping is private: it may be modified in place, and stays valid until
+// release is called.
+type FileMapper interface {
+	MapFile(ctx context.Context, uri *url.URL) (data []byte, release func(), err error)
+}
+
+// FileRanger is implemented by sources able to read part of a file without
+// reading all of it. A negative size reads up to the end of the file.
+type FileRanger interface {
+	ReadRange(ctx context.Context, uri *url.URL, off, size int64) ([]byte, error)
+}
+
+// torrentSource reads files of torrents in the storage, addressed as
+// torrent://<infohash>/<path>.
+type torrentSource struct {
+	fs torrentfs.CortexStorage
+}
+
+func (t *torrentSource) ReadFile(ctx context.Context, uri *url.URL) ([]byte, error) {
+	if t.fs == nil {
+		return nil, fmt.Errorf("no storage for %v", uri)
+	}
+	return t.fs.GetFile(ctx, strings.ToLower(strings.TrimPrefix(uri.Host, "0x")), uri.Path)
+}
+
+// MapFile maps files of storages keeping completed torrents on disk.
+func (t *torrentSource) MapFile(ctx context.Context, uri *url.URL) ([]byte, func(), error) {
+	fs, ok := t.fs.(interface {
+		FilePath(ctx context.Context, infohash, subpath string) (string, error)
+	})
+	if !ok {
+		return nil, nil, errNotMappable
+	}
+	path, err := fs.FilePath(ctx, strings.ToLower(strings.TrimPrefix(uri.Host, "0x")), uri.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mapFile(path)
+}
+
+// ReadRange reads part of a file of a torrent, which may still be
+// downloading: the pieces holding the range are fetched first.
+func (t *torrentSource) ReadRange(ctx context.Context, uri *url.URL, off, size int64) ([]byte, error) {
+	fs, ok := t.fs.(interface {
+		ReadRange(ctx context.Context, infohash, subpath string, off, size int64) ([]byte, error)
+	})
+	if !ok {
+		return nil, errNoRange
+	}
+	return fs.ReadRange(ctx, strings.ToLower(strings.TrimPrefix(uri.Host, "0x")), uri.Path, off, size)
+}
+
+// localSource reads files from the local file system.
+type localSource struct{}
+
+func (localSource) ReadFile(ctx context.Context, uri *url.URL) ([]byte, error) {
+	return ioutil.ReadFile(uri.Path)
+}
+
+func (localSource) MapFile(ctx context.Context, uri *url.URL) ([]byte, func(), error) {
+	return mapFile(uri.Path)
+}
+
+func (localSource) ReadRange(ctx context.Context, uri *url.URL, off, size int64) ([]byte, error) {
+	return readFileRange(uri.Path, off, size)
+}
+
+// dirSource reads torrent files straight from a storage data directory,
+// laid out as <root>/<infohash>/<path>, without running the storage.
+type dirSource struct {
+	root string
+}
+
+// NewDirSource returns a source serving torrent:// uris from the data
+// directory of a stopped storage.
+func NewDirSource(root string) FileSource {
+	return &dirSource{root}
+}
+
+func (d *dirSource) ReadFile(ctx context.Context, uri *url.URL) ([]byte, error) {
+	ih := strings.ToLower(strings.TrimPrefix(uri.Host, "0x"))
+	return ioutil.ReadFile(filepath.Join(d.root, ih, filepath.FromSlash(uri.Path)))
+}
+
+func (d *dirSource) MapFile(ctx context.Context, uri *url.URL) ([]byte, func(), error) {
+	ih := strings.ToLower(strings.TrimPrefix(uri.Host, "0x"))
+	return mapFile(filepath.Join(d.root, ih, filepath.FromSlash(uri.Path)))
+}
+
+func (d *dirSource) ReadRange(ctx context.Context, uri *url.URL, off, size int64) ([]byte, error) {
+	ih := strings.ToLower(strings.TrimPrefix(uri.Host, "0x"))
+	return readFileRange(filepath.Join(d.root, ih, filepath.FromSlash(uri.Path)), off, size)
+}
+
+// readFileRange reads size bytes at off of a local file, the rest of the
+// file if size is negative.
+func readFileRange(path string, off, size int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		size = info.Size() - off
+	}
+	if off < 0 || size < 0 || off+size > info.Size() {
+		return nil, fmt.Errorf("range %d+%d beyond the end of %s", off, size, path)
+	}
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, off); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// httpSource downloads files from http(s) urls.
+type httpSource struct {
+	client *http.Client
+}
+
+func (h *httpSource) ReadFile(ctx context.Context, uri *url.URL) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, uri.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %v: %s", uri, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHTTPFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxHTTPFileSize {
+		return nil, fmt.Errorf("fetch %v: file exceeds %d bytes", uri, maxHTTPFileSize)
+	}
+	return data, nil
+}
+
+// TorrentURI returns the uri of a file inside a torrent of the storage.
+func TorrentURI(infohash, path string) string {
+	return "torrent://" + infohash + path
+}
+
+// RegisterSource makes files with the given uri scheme readable by ReadFile.
+func (s *Synapse) RegisterSource(scheme string, src FileSource) {
+	s.sources.Store(scheme, src)
+}
+
+// ReadFile reads a model or input file, picking the source by uri scheme:
+// torrent://<infohash>/<path> for the storage, http(s):// urls, and file://
+// urls or plain paths for the local file system.
+func (s *Synapse) ReadFile(ctx context.Context, uri string) ([]byte, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	scheme := u.Scheme
+	if scheme == "" {
+		scheme = "file"
+	}
+	src, ok := s.sources.Load(scheme)
+	if !ok {
+		return nil, fmt.Errorf("unsupported file source %q", scheme)
+	}
+	return src.(FileSource).ReadFile(ctx, u)
+}
+
+// MapFile maps a file into memory if its source supports it, returning
+// errNotMappable otherwise. Callers fall back to ReadFile on any error.
+func (s *Synapse) MapFile(ctx context.Context, uri string) ([]byte, func(), error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	scheme := u.Scheme
+	if scheme == "" {
+		scheme = "file"
+	}
+	src, ok := s.sources.Load(scheme)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported file source %q", scheme)
+	}
+	mapper, ok := src.(FileMapper)
+	if !ok {
+		return nil, nil, errNotMappable
+	}
+	return mapper.MapFile(ctx, u)
+}
+
+// ReadRange reads size bytes at off of a model or input file, the rest of the
+// file if size is negative. Torrent files are read from the pieces holding
+// the range, so the torrent doesn't have to be complete. Sources unable to
+// read ranges have the whole file read.
+func (s *Synapse) ReadRange(ctx context.Context, uri string, off, size int64) ([]byte, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	scheme := u.Scheme
+	if scheme == "" {
+		scheme = "file"
+	}
+	src, ok := s.sources.Load(scheme)
+	if !ok {
+		return nil, fmt.Errorf("unsupported file source %q", scheme)
+	}
+	if ranger, ok := src.(FileRanger); ok {
+		data, err := ranger.ReadRange(ctx, u, off, size)
+		if err != errNoRange {
+			return data, err
+		}
+	}
+	data, err := src.(FileSource).ReadFile(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		size = int64(len(data)) - off
+	}
+	if off < 0 || size < 0 || off+size > int64(len(data)) {
+		return nil, fmt.Errorf("range %d+%d beyond the end of %v", off, size, uri)
+	}
+	return data[off : off+size], nil
+}
+
+func (s *Synapse) registerDefaultSources() {
+	s.RegisterSource("torrent", &torrentSource{s.config.Storagefs})
+	s.RegisterSource("file", localSource{})
+	web := &httpSource{&http.Client{}}
+	s.RegisterSource("http", web)
+	s.RegisterSource("https", web)
+}
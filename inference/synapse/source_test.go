@@ -0,0 +1,132 @@
+package synapse
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	s := &Synapse{config: &Config{}}
+	s.registerDefaultSources()
+
+	dir, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "input")
+	if err := ioutil.WriteFile(path, []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("remote"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{path, "local"},
+		{"file://" + path, "local"},
+		{server.URL + "/input", "remote"},
+	}
+	for _, tt := range tests {
+		data, err := s.ReadFile(context.Background(), tt.uri)
+		if err != nil || !bytes.Equal(data, []byte(tt.want)) {
+			t.Errorf("ReadFile(%s) = %q, %v, want %q", tt.uri, data, err, tt.want)
+		}
+	}
+	if _, err := s.ReadFile(context.Background(), "ftp://host/file"); err == nil {
+		t.Errorf("unsupported scheme accepted")
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	s := &Synapse{config: &Config{}}
+	s.registerDefaultSources()
+
+	dir, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "input")
+	if err := ioutil.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		uri       string
+		off, size int64
+		want      string
+	}{
+		{path, 2, 3, "234"},
+		{path, 7, -1, "789"},
+		{path, 10, 0, ""},
+		{server.URL + "/input", 4, 2, "45"},
+		{server.URL + "/input", 0, -1, "0123456789"},
+	}
+	for _, tt := range tests {
+		data, err := s.ReadRange(context.Background(), tt.uri, tt.off, tt.size)
+		if err != nil || !bytes.Equal(data, []byte(tt.want)) {
+			t.Errorf("ReadRange(%s, %d, %d) = %q, %v, want %q", tt.uri, tt.off, tt.size, data, err, tt.want)
+		}
+	}
+	for _, uri := range []string{path, server.URL + "/input"} {
+		if _, err := s.ReadRange(context.Background(), uri, 8, 3); err == nil {
+			t.Errorf("range beyond the end of %s read", uri)
+		}
+	}
+}
+
+func TestMapFile(t *testing.T) {
+	s := &Synapse{config: &Config{}}
+	s.registerDefaultSources()
+
+	dir, err := ioutil.TempDir("", "synapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	small, large := filepath.Join(dir, "small"), filepath.Join(dir, "large")
+	content := bytes.Repeat([]byte{7}, mapThreshold)
+	if err := ioutil.WriteFile(small, content[:16], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(large, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.MapFile(context.Background(), small); err == nil {
+		t.Errorf("small file mapped")
+	}
+	if _, _, err := s.MapFile(context.Background(), "http://host/large"); err != errNotMappable {
+		t.Errorf("http file mapped: %v", err)
+	}
+	data, release, err := s.MapFile(context.Background(), large)
+	if err == errNotMappable {
+		t.Skip("mmap unsupported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatalf("mapped content mismatch")
+	}
+	// The mapping is private, writes must not reach the file.
+	data[0] = 1
+	release()
+	if stored, err := ioutil.ReadFile(large); err != nil || !bytes.Equal(stored, content) {
+		t.Errorf("file modified through the mapping")
+	}
+}
@@ -3,12 +3,12 @@ package synapse
 import (
 	"context"
 	"fmt"
-	"github.com/CortexFoundation/CortexTheseus/common/lru"
-	"github.com/CortexFoundation/CortexTheseus/cvm-runtime/kernel"
 	"github.com/CortexFoundation/CortexTheseus/log"
 	"github.com/CortexFoundation/torrentfs"
-	"strconv"
+	glru "github.com/hashicorp/golang-lru"
+	"runtime"
 	"sync"
+	"time"
 )
 
 var (
@@ -16,13 +16,17 @@ var (
 
 	DefaultConfig Config = Config{
 		// StorageDir:    "",
-		IsNotCache:     false,
-		DeviceType:     "cpu",
-		DeviceId:       0,
-		IsRemoteInfer:  false,
-		InferURI:       "",
-		Debug:          false,
-		MaxMemoryUsage: 4 * 1024 * 1024 * 1024,
+		IsNotCache:      false,
+		DeviceType:      "cpu",
+		DeviceId:        0,
+		IsRemoteInfer:   false,
+		InferURI:        "",
+		Debug:           false,
+		MaxMemoryUsage:  4 * 1024 * 1024 * 1024,
+		ResultCacheSize: 4096,
+		DeviceQueue:     8,
+		Workers:         runtime.NumCPU(),
+		InferTimeout:    5 * time.Minute,
 	}
 )
 
@@ -43,20 +47,71 @@ type Config struct {
 	InferURI       string `toml:",omitempty"`
 	Debug          bool   `toml:",omitempty"`
 	MaxMemoryUsage int64
-	Storagefs      torrentfs.CortexStorage
+	// ResultCacheSize is the number of inference results kept in memory,
+	// ResultCacheJournal optionally persists them across restarts.
+	ResultCacheSize    int    `toml:",omitempty"`
+	ResultCacheJournal string `toml:",omitempty"`
+	// DeviceIds spreads inference over several devices, DeviceQueue is the
+	// number of pending requests after which a device holding the model is
+	// bypassed for a less busy one.
+	DeviceIds   []int `toml:",omitempty"`
+	DeviceQueue int   `toml:",omitempty"`
+	// Deterministic forces the integer cpu kernels and refuses models with
+	// operators that can't be verified bit-identical across nodes.
+	Deterministic bool `toml:",omitempty"`
+	// Workers bounds the inferences running at once, InferTimeout is the
+	// deadline of a single inference including its time in the queue.
+	Workers      int           `toml:",omitempty"`
+	InferTimeout time.Duration `toml:",omitempty"`
+	// AuditLog is the file recording every consensus inference, empty
+	// disables auditing.
+	AuditLog string `toml:",omitempty"`
+	// DumpDir receives a replayable dump of every consensus inference,
+	// empty disables dumping.
+	DumpDir string `toml:",omitempty"`
+	// Backends names the inference backends in order of preference, each
+	// model runs on the first one able to, defaulting to cvm.
+	Backends []string `toml:",omitempty"`
+	// Sandbox runs native inferences in worker processes, each limited to
+	// SandboxMemory bytes and SandboxOutput bytes of output, and killed at
+	// the inference deadline. SandboxCgroup is a delegated cgroup v2
+	// directory enforcing the memory limit, without it workers fall back to
+	// an rlimit. SandboxCommand starts a worker, defaulting to the synapse
+	// worker command of the running binary.
+	Sandbox        bool     `toml:",omitempty"`
+	SandboxMemory  int64    `toml:",omitempty"`
+	SandboxOutput  int      `toml:",omitempty"`
+	SandboxCgroup  string   `toml:",omitempty"`
+	SandboxCommand []string `toml:",omitempty"`
+	// WarmLoad loads models into the cache as soon as the storage completes
+	// their download, provided they fit the free memory of a device, so the
+	// first inference doesn't pay the cold load. Completed models are
+	// validated either way.
+	WarmLoad  bool `toml:",omitempty"`
+	Storagefs torrentfs.CortexStorage
 }
 
 type Synapse struct {
 	config      *Config
-	simpleCache sync.Map
+	simpleCache *glru.Cache
 	gasCache    sync.Map
+	preloads    sync.Map
+	metas       sync.Map
+	unloadable  sync.Map // models refused at load, by hash
+	sources     sync.Map
 	//modelLock   sync.Map
-	mutex  sync.Mutex
-	lib    *kernel.LibCVM
-	caches map[int]*lru.Cache
+	mutex     sync.Mutex // guards the scheduling state of the devices
+	backends  []*namedBackend
+	devices   []*device
+	placement map[string]*device
 	//exitCh chan struct{}
 
-	ctx context.Context
+	tasks   chan *inferTask
+	audit   *auditLog
+	sandbox *sandbox
+
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func Engine() *Synapse {
@@ -69,7 +124,6 @@ func Engine() *Synapse {
 }
 
 func New(config *Config) *Synapse {
-	path := PLUGIN_PATH + config.DeviceType + PLUGIN_POST_FIX
 	if synapseInstance != nil {
 		log.Warn("Synapse Engine has been initalized")
 		if config.Debug {
@@ -77,45 +131,88 @@ func New(config *Config) *Synapse {
 		}
 		return synapseInstance
 	}
-	var lib *kernel.LibCVM
-	var status int
+	if config.Deterministic && config.DeviceType != "cpu" {
+		log.Warn("Deterministic inference forces the cpu device", "device", config.DeviceType)
+		config.DeviceType = "cpu"
+	}
+	var backends []*namedBackend
 	if !config.IsRemoteInfer {
-		lib, status = kernel.LibOpen(path)
-		if status != kernel.SUCCEED {
-			log.Error("infer helper", "init cvm plugin error", "")
+		if backends = openBackends(config); len(backends) == 0 {
+			log.Error("No inference backend available", "backends", config.Backends)
 			if config.Debug {
-				fmt.Println("infer helper", "init cvm plugin error", "")
+				fmt.Println("No inference backend available", config.Backends)
 			}
 			return nil
 		}
-		if lib == nil {
-			panic("lib_path = " + PLUGIN_PATH + config.DeviceType + PLUGIN_POST_FIX + " config.IsRemoteInfer = " + strconv.FormatBool(config.IsRemoteInfer))
-		}
 	}
 
 	synapseInstance = &Synapse{
-		config: config,
-		lib:    lib,
+		config:   config,
+		backends: backends,
 		//exitCh: make(chan struct{}),
-		caches: make(map[int]*lru.Cache),
+		placement: make(map[string]*device),
+	}
+	if config.DeviceQueue <= 0 {
+		config.DeviceQueue = DefaultConfig.DeviceQueue
+	}
+	synapseInstance.devices = synapseInstance.newDevices()
+	synapseInstance.registerDefaultSources()
+	if config.AuditLog != "" {
+		audit, err := openAuditLog(config.AuditLog)
+		if err != nil {
+			log.Error("Failed to open inference audit log", "path", config.AuditLog, "err", err)
+		} else {
+			synapseInstance.audit = audit
+		}
+	}
+	if !config.IsNotCache {
+		synapseInstance.simpleCache = newResultCache(config.ResultCacheSize, config.ResultCacheJournal)
+	}
+
+	synapseInstance.ctx, synapseInstance.cancel = context.WithCancel(context.Background())
+	if !config.IsRemoteInfer {
+		if config.Workers <= 0 {
+			config.Workers = DefaultConfig.Workers
+		}
+		synapseInstance.startWorkers()
+		if src, ok := config.Storagefs.(completionSource); ok {
+			go synapseInstance.watchCompleted(src)
+		}
+		if config.Sandbox {
+			sb, err := newSandbox(synapseInstance)
+			if err != nil {
+				log.Error("Failed to set up the inference sandbox", "err", err)
+			} else {
+				synapseInstance.sandbox = sb
+			}
+		}
 	}
 
-	synapseInstance.ctx = context.Background()
+	torrentfs.RegisterDashboard("synapse", func() interface{} { return synapseInstance.EngineStats() })
 
-	log.Info("Initialising Synapse Engine", "Cache Disabled", config.IsNotCache)
+	log.Info("Initialising Synapse Engine", "Cache Disabled", config.IsNotCache, "deterministic", config.Deterministic, "sandbox", synapseInstance.sandbox != nil)
 	return synapseInstance
 }
 
 func (s *Synapse) Close() {
 	//close(s.exitCh)
-	if s.config.Storagefs != nil {
-		s.config.Storagefs.Stop()
+	s.cancel()
+	if s.sandbox != nil {
+		s.sandbox.close()
 	}
-	for _, c := range s.caches {
-		if c != nil {
-			c.Clear()
+	if s.simpleCache != nil && s.config.ResultCacheJournal != "" {
+		if err := saveResultCache(s.simpleCache, s.config.ResultCacheJournal); err != nil {
+			log.Warn("Failed to save inference cache journal", "err", err)
 		}
 	}
+	if s.audit != nil {
+		s.audit.close()
+	}
+	for _, d := range s.devices {
+		d.lock.Lock()
+		d.cache.Clear()
+		d.lock.Unlock()
+	}
 	log.Info("Synapse Engine Closed")
 }
 
@@ -123,14 +220,14 @@ func (s *Synapse) InferByInfoHash(modelInfoHash, inputInfoHash string) ([]byte,
 	if s.config.IsRemoteInfer {
 		return s.remoteInferByInfoHash(modelInfoHash, inputInfoHash)
 	}
-	return s.inferByInfoHash(modelInfoHash, inputInfoHash)
+	return s.inferQueued(modelInfoHash, inputInfoHash, nil)
 }
 
 func (s *Synapse) InferByInputContent(modelInfoHash string, inputContent []byte) ([]byte, error) {
 	if s.config.IsRemoteInfer {
 		return s.remoteInferByInputContent(modelInfoHash, inputContent)
 	}
-	return s.inferByInputContent(modelInfoHash, inputContent)
+	return s.inferQueued(modelInfoHash, "", inputContent)
 }
 
 func (s *Synapse) GetGasByInfoHash(modelInfoHash string) (gas uint64, err error) {
@@ -1,10 +1,11 @@
 package synapse
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"io"
 
-	//"bytes"
 	"github.com/CortexFoundation/CortexTheseus/common"
 	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
 	"github.com/CortexFoundation/CortexTheseus/inference"
@@ -44,6 +45,45 @@ func ReadData(r *inference.NpyReader) ([]byte, error) {
 	}
 }
 
+// ReadDataInPlace decodes an npy array held in buf like ReadData, returning
+// a slice of buf instead of a copy. Big-endian int32 arrays are swapped to
+// little-endian in place, so buf has to be owned by the caller, e.g. a
+// private mapping of the file.
+func ReadDataInPlace(buf []byte) ([]byte, error) {
+	br := bytes.NewReader(buf)
+	r, err := inference.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	n := 1
+	for _, d := range r.Shape {
+		if d < 0 {
+			return nil, errors.New("negative dimension in shape")
+		}
+		n *= d
+	}
+	payload := buf[len(buf)-br.Len():]
+	switch r.Dtype {
+	case "i1":
+		if len(payload) < n {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return payload[:n], nil
+	case "i4":
+		if len(payload)/4 < n {
+			return nil, io.ErrUnexpectedEOF
+		}
+		data := payload[:4*n]
+		if r.Endian == binary.BigEndian {
+			for i := 0; i < len(data); i += 4 {
+				data[i], data[i+1], data[i+2], data[i+3] = data[i+3], data[i+2], data[i+1], data[i]
+			}
+		}
+		return data, nil
+	}
+	return nil, errors.New("not support dtype for " + r.Dtype)
+}
+
 func RLPHashString(x interface{}) string {
 	var h common.Hash
 	hw := sha3.NewLegacyKeccak256()
@@ -1,6 +1,7 @@
 package synapse
 
 import (
+	"bytes"
 	"github.com/CortexFoundation/CortexTheseus/inference"
 	"io/ioutil"
 	"os"
@@ -53,3 +54,46 @@ func TestRLPHashString(t *testing.T) {
 		t.Log("rlp hash", "data", data, "rlp", rlp)
 	}
 }
+
+// npyFile encodes an npy version 1 file with the given dtype descriptor.
+func npyFile(descr string, shape string, payload []byte) []byte {
+	header := "{'descr': '" + descr + "', 'fortran_order': False, 'shape': (" + shape + "), }"
+	for (10+len(header)+1)%16 != 0 {
+		header += " "
+	}
+	header += "\n"
+	buf := []byte("\x93NUMPY\x01\x00")
+	buf = append(buf, byte(len(header)), byte(len(header)>>8))
+	buf = append(buf, header...)
+	return append(buf, payload...)
+}
+
+func TestReadDataInPlace(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"i1", npyFile("|i1", "2, 3", []byte{1, 2, 3, 0xfe, 5, 6})},
+		{"i4 little", npyFile("<i4", "2,", []byte{1, 0, 0, 0, 0xff, 0xff, 0xff, 0xfe})},
+		{"i4 big", npyFile(">i4", "2,", []byte{0, 0, 0, 1, 0xfe, 0xff, 0xff, 0xff})},
+		{"trailing bytes", npyFile("<i4", "1,", []byte{7, 0, 0, 0, 9})},
+		{"truncated", npyFile("<i4", "2,", []byte{1, 0, 0, 0, 2})},
+		{"dtype", npyFile("<f4", "1,", []byte{0, 0, 0, 0})},
+	}
+	for _, tt := range tests {
+		rdr, err := inference.NewBytesReader(tt.file)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want, wantErr := ReadData(rdr)
+		buf := append([]byte(nil), tt.file...)
+		got, err := ReadDataInPlace(buf)
+		if (err != nil) != (wantErr != nil) {
+			t.Errorf("%s: error %v, ReadData error %v", tt.name, err, wantErr)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: decoded %x, ReadData %x", tt.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package synapse
+
+import (
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/metrics"
+)
+
+var warmUpTimer = metrics.NewRegisteredTimer("synapse/warmup", nil)
+
+// warmUp prepares a freshly loaded model for inference, holding a reference
+// taken by loadModel. A dummy inference lets the backend select its kernels
+// before the first real one.
+func (s *Synapse) warmUp(d *device, modelHash string, mc *modelContext) {
+	mc.lock.Lock()
+	if s.ctx.Err() == nil {
+		start := time.Now()
+		if _, err := mc.backend.Infer(mc.model, make([]byte, mc.model.GetInputLength())); err != nil {
+			log.Debug("Model warm-up inference failed", "hash", modelHash, "err", err)
+		} else {
+			warmUpTimer.UpdateSince(start)
+			log.Debug("Model warmed up", "hash", modelHash, "device", d.id, "elapsed", time.Since(start))
+		}
+	}
+	mc.lock.Unlock()
+
+	d.lock.Lock()
+	if mc.refs--; mc.refs == 0 && mc.evicted {
+		mc.backend.Free(mc.model)
+	}
+	d.lock.Unlock()
+}
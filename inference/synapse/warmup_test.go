@@ -0,0 +1,61 @@
+package synapse
+
+import (
+	"context"
+	"testing"
+)
+
+type testModel struct{}
+
+func (testModel) Ops() uint64               { return 0 }
+func (testModel) Size() uint64              { return 1 }
+func (testModel) GetInputLength() uint64    { return 4 }
+func (testModel) GetInputTypeSize() uint64  { return 1 }
+func (testModel) GetOutputTypeSize() uint64 { return 1 }
+
+// warmBackend reports the inferences and frees of its models.
+type warmBackend struct {
+	testBackend
+	inferred chan []byte
+	freed    chan Model
+}
+
+func (b *warmBackend) Infer(model Model, input []byte) ([]byte, error) {
+	b.inferred <- input
+	return input, nil
+}
+
+func (b *warmBackend) Free(model Model) { b.freed <- model }
+
+func TestWarmUp(t *testing.T) {
+	s := &Synapse{config: &Config{}}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	defer s.cancel()
+	d := &device{}
+	b := &warmBackend{inferred: make(chan []byte, 1), freed: make(chan Model, 1)}
+	mc := &modelContext{model: testModel{}, backend: &namedBackend{"test", b}, refs: 2}
+
+	// A model evicted while warming up is freed by the last holder.
+	mc.lock.Lock()
+	go s.warmUp(d, "aa", mc)
+	d.lock.Lock()
+	mc.refs--
+	mc.evicted = true
+	d.lock.Unlock()
+	mc.lock.Unlock()
+	<-b.freed
+
+	if input := <-b.inferred; len(input) != 4 {
+		t.Errorf("warm-up input length %d, want 4", len(input))
+	}
+
+	// Nothing runs once the engine is closed.
+	s.cancel()
+	mc = &modelContext{model: testModel{}, backend: &namedBackend{"test", b}, refs: 1}
+	s.warmUp(d, "aa", mc)
+	select {
+	case <-b.inferred:
+		t.Error("model warmed up after close")
+	default:
+	}
+}
@@ -70,4 +70,7 @@ const (
 type InferResult struct {
 	Data hexutil.Bytes `json:"data"`
 	Info string        `json:"info"`
+	// Code is the versioned error code of a failed inference, absent in
+	// responses of servers predating error codes.
+	Code hexutil.Bytes `json:"code,omitempty"`
 }
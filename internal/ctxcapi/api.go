@@ -527,6 +527,22 @@ func (s *PublicBlockChainAPI) GetBlockByNumber(ctx context.Context, blockNr rpc.
 	return nil, err
 }
 
+// GetStorageBloom returns the storage bloom of the requested block, which lets
+// the storage layer skip blocks without model meta or upload transactions
+// without fetching their transactions in full detail.
+func (s *PublicBlockChainAPI) GetStorageBloom(ctx context.Context, blockNr rpc.BlockNumber) (map[string]interface{}, error) {
+	block, err := s.b.BlockByNumber(ctx, blockNr)
+	if block == nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"number":       (*hexutil.Big)(block.Number()),
+		"hash":         block.Hash(),
+		"bloom":        types.StorageBloom(block.Transactions()),
+		"transactions": hexutil.Uint(len(block.Transactions())),
+	}, nil
+}
+
 // GetBlockByHash returns the requested block. When fullTx is true all transactions in the block are returned in full
 // detail, otherwise only the transaction hash is returned.
 func (s *PublicBlockChainAPI) GetBlockByHash(ctx context.Context, blockHash common.Hash, fullTx bool) (map[string]interface{}, error) {
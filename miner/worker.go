@@ -918,7 +918,7 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64)
 	}
 
 	// Fill the block with all available pending transactions.
-	pending, err := w.ctxc.TxPool().Pending()
+	pending, err := w.ctxc.TxPool().PendingReady()
 	if err != nil {
 		log.Error("Failed to fetch pending transactions", "err", err)
 		return
@@ -53,6 +53,7 @@ type Node struct {
 
 	serviceFuncs []ServiceConstructor     // Service constructors (in dependency order)
 	services     map[reflect.Type]Service // Currently running services
+	serviceOrder []reflect.Type           // Kinds of the running services (in dependency order)
 
 	rpcAPIs       []rpc.API   // List of APIs currently provided by the node
 	inprocHandler *rpc.Server // In-process RPC request handler to process the API requests
@@ -194,7 +195,10 @@ func (n *Node) Start() error {
 	n.log.Info("Starting peer-to-peer node", "instance", n.serverConfig.Name)
 
 	// Otherwise copy and specialize the P2P configuration
-	services := make(map[reflect.Type]Service)
+	var (
+		services = make(map[reflect.Type]Service)
+		order    []reflect.Type
+	)
 	for _, constructor := range n.serviceFuncs {
 		// Create a new context for the particular service
 		ctx := &ServiceContext{
@@ -216,44 +220,52 @@ func (n *Node) Start() error {
 			return &DuplicateServiceError{Kind: kind}
 		}
 		services[kind] = service
+		order = append(order, kind)
 	}
 	// Gather the protocols and start the freshly assembled P2P server
-	for _, service := range services {
-		running.Protocols = append(running.Protocols, service.Protocols()...)
+	for _, kind := range order {
+		running.Protocols = append(running.Protocols, services[kind].Protocols()...)
 	}
 	if err := running.Start(); err != nil {
 		return convertFileLockError(err)
 	}
-	// Start each of the services
-	var started []reflect.Type
-	for kind, service := range services {
+	// Start each of the services in dependency order
+	for i, kind := range order {
 		// Start the next service, stopping all previous upon failure
-		if err := service.Start(running); err != nil {
-			for _, kind := range started {
-				services[kind].Stop()
-			}
+		if err := services[kind].Start(running); err != nil {
+			stopServices(services, order[:i])
 			running.Stop()
 
 			return err
 		}
-		// Mark the service started for potential cleanup
-		started = append(started, kind)
 	}
 	// Lastly, start the configured RPC interfaces
 	if err := n.startRPC(services); err != nil {
-		for _, service := range services {
-			service.Stop()
-		}
+		stopServices(services, order)
 		running.Stop()
 		return err
 	}
 	// Finish initializing the startup
 	n.services = services
+	n.serviceOrder = order
 	n.server = running
 	n.stop = make(chan struct{})
 	return nil
 }
 
+// stopServices stops the services of the given kinds in reverse order, so
+// every service is stopped before the ones it depends on, and returns the
+// failures.
+func stopServices(services map[reflect.Type]Service, order []reflect.Type) map[reflect.Type]error {
+	failures := make(map[reflect.Type]error)
+	for i := len(order) - 1; i >= 0; i-- {
+		if err := services[order[i]].Stop(); err != nil {
+			failures[order[i]] = err
+		}
+	}
+	return failures
+}
+
 // Config returns the configuration of node.
 func (n *Node) Config() *Config {
 	return n.config
@@ -474,13 +486,12 @@ func (n *Node) Stop() error {
 	failure := &StopError{
 		Services: make(map[reflect.Type]error),
 	}
-	for kind, service := range n.services {
-		if err := service.Stop(); err != nil {
-			failure.Services[kind] = err
-		}
+	for kind, err := range stopServices(n.services, n.serviceOrder) {
+		failure.Services[kind] = err
 	}
 	n.server.Stop()
 	n.services = nil
+	n.serviceOrder = nil
 	n.server = nil
 
 	// Release instance directory lock.
@@ -198,6 +198,43 @@ func TestServiceLifeCycle(t *testing.T) {
 	}
 }
 
+// Tests that services are started in registration order and stopped in the
+// reverse one, so a service never outlives the ones it depends on.
+func TestServiceStartStopOrder(t *testing.T) {
+	stack, err := New(testNodeConfig())
+	if err != nil {
+		t.Fatalf("failed to create protocol stack: %v", err)
+	}
+	defer stack.Close()
+
+	var events []string
+	for _, svc := range []struct {
+		id    string
+		maker InstrumentingWrapper
+	}{{"A", InstrumentedServiceMakerA}, {"B", InstrumentedServiceMakerB}, {"C", InstrumentedServiceMakerC}} {
+		id := svc.id // Closure for the constructor
+		constructor := func(*ServiceContext) (Service, error) {
+			return &InstrumentedService{
+				startHook: func(*p2p.Server) { events = append(events, "start "+id) },
+				stopHook:  func() { events = append(events, "stop "+id) },
+			}, nil
+		}
+		if err := stack.Register(svc.maker(constructor)); err != nil {
+			t.Fatalf("service %s: registration failed: %v", id, err)
+		}
+	}
+	if err := stack.Start(); err != nil {
+		t.Fatalf("failed to start protocol stack: %v", err)
+	}
+	if err := stack.Stop(); err != nil {
+		t.Fatalf("failed to stop protocol stack: %v", err)
+	}
+	want := []string{"start A", "start B", "start C", "stop C", "stop B", "stop A"}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("life-cycle order mismatch: have %v, want %v", events, want)
+	}
+}
+
 // Tests that services are restarted cleanly as new instances.
 func TestServiceRestarts(t *testing.T) {
 	stack, err := New(testNodeConfig())
@@ -0,0 +1,305 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"golang.org/x/time/rate"
+)
+
+const (
+	bandwidthInterval = time.Second // interval the node bandwidth is split again
+	bandwidthHeadroom = 1.25        // growth allowed to users below their share per interval
+	bandwidthFloor    = 8           // fraction of its share a user always keeps
+	bandwidthBurst    = 64 * 1024   // burst of the devp2p limiters
+)
+
+var errInvalidBandwidthUser = errors.New("bandwidth user needs a name, a positive share and both limiters")
+
+// BandwidthUser is a consumer of the node bandwidth, like devp2p or the
+// storage, whose rate limiters are adjusted by the bandwidth manager.
+type BandwidthUser struct {
+	Name    string
+	Share   int                             // weight of the user in the split of the cap
+	Egress  *rate.Limiter                   // limiter of the outbound traffic
+	Ingress *rate.Limiter                   // limiter of the inbound traffic
+	Traffic func() (egress, ingress uint64) // bytes transferred so far
+
+	ceiling [2]rate.Limit // limits of the user at registration, never exceeded
+	last    [2]uint64
+}
+
+// BandwidthManager keeps the total traffic of the node under one cap by
+// splitting it between the registered users in proportion to their shares.
+// The part of a share a user leaves idle goes to the busy ones, so a single
+// active user may use nearly the whole cap.
+type BandwidthManager struct {
+	limit rate.Limit
+
+	lock  sync.Mutex
+	users []*BandwidthUser
+}
+
+// NewBandwidthManager creates a manager capping the traffic at limit bytes
+// per second in each direction.
+func NewBandwidthManager(limit int) *BandwidthManager {
+	return &BandwidthManager{limit: rate.Limit(limit)}
+}
+
+// Limit returns the cap in bytes per second.
+func (m *BandwidthManager) Limit() int {
+	return int(m.limit)
+}
+
+// Register adds a user, replacing a previous one of the same name. The
+// limits of its limiters at registration stay an upper bound of what it is
+// allocated.
+func (m *BandwidthManager) Register(u *BandwidthUser) error {
+	if u.Name == "" || u.Share <= 0 || u.Egress == nil || u.Ingress == nil || u.Traffic == nil {
+		return errInvalidBandwidthUser
+	}
+	u.ceiling = [2]rate.Limit{u.Egress.Limit(), u.Ingress.Limit()}
+	u.last[0], u.last[1] = u.Traffic()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.remove(u.Name)
+	m.users = append(m.users, u)
+	for dir := range u.ceiling {
+		m.apply(dir, allocateBandwidth(float64(m.limit), m.shares(), nil))
+	}
+	log.Info("Bandwidth user registered", "name", u.Name, "share", u.Share, "cap", m.limit)
+	return nil
+}
+
+// Unregister removes a user and restores the limits it was registered with.
+func (m *BandwidthManager) Unregister(name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if u := m.remove(name); u != nil {
+		u.Egress.SetLimit(u.ceiling[0])
+		u.Ingress.SetLimit(u.ceiling[1])
+	}
+}
+
+func (m *BandwidthManager) remove(name string) *BandwidthUser {
+	for i, u := range m.users {
+		if u.Name == name {
+			m.users = append(m.users[:i], m.users[i+1:]...)
+			return u
+		}
+	}
+	return nil
+}
+
+// loop splits the cap again every interval, until quit is closed.
+func (m *BandwidthManager) loop(quit <-chan struct{}) {
+	ticker := time.NewTicker(bandwidthInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.lock.Lock()
+			m.rebalance(now.Sub(last).Seconds())
+			m.lock.Unlock()
+			last = now
+		case <-quit:
+			return
+		}
+	}
+}
+
+// rebalance sets the limiters of all users from the traffic they had over
+// the last elapsed seconds.
+func (m *BandwidthManager) rebalance(elapsed float64) {
+	if len(m.users) == 0 || elapsed <= 0 {
+		return
+	}
+	used := [2][]float64{make([]float64, len(m.users)), make([]float64, len(m.users))}
+	for i, u := range m.users {
+		egress, ingress := u.Traffic()
+		for dir, total := range []uint64{egress, ingress} {
+			if total > u.last[dir] {
+				used[dir][i] = float64(total-u.last[dir]) / elapsed
+			}
+			u.last[dir] = total
+		}
+	}
+	for dir := range used {
+		m.apply(dir, allocateBandwidth(float64(m.limit), m.shares(), used[dir]))
+	}
+}
+
+func (m *BandwidthManager) shares() []int {
+	shares := make([]int, len(m.users))
+	for i, u := range m.users {
+		shares[i] = u.Share
+	}
+	return shares
+}
+
+// apply sets the limiters of one direction, 0 for egress and 1 for ingress,
+// to an allocation.
+func (m *BandwidthManager) apply(dir int, alloc []float64) {
+	for i, u := range m.users {
+		limit := rate.Limit(alloc[i])
+		if limit > u.ceiling[dir] {
+			limit = u.ceiling[dir]
+		}
+		if dir == 0 {
+			u.Egress.SetLimit(limit)
+		} else {
+			u.Ingress.SetLimit(limit)
+		}
+	}
+}
+
+// allocateBandwidth splits limit by shares. Users below their share keep
+// what they used plus some headroom to ramp up, but at least a fraction of
+// their share, the rest of it goes to the others in proportion to their
+// shares. Without usage, limit is split by shares alone.
+func allocateBandwidth(limit float64, shares []int, used []float64) []float64 {
+	total := 0
+	for _, s := range shares {
+		total += s
+	}
+	alloc := make([]float64, len(shares))
+	if total == 0 {
+		return alloc
+	}
+	var (
+		idle  = make([]bool, len(shares))
+		spare float64
+		busy  int
+	)
+	for i, s := range shares {
+		alloc[i] = limit * float64(s) / float64(total)
+		if used == nil {
+			continue
+		}
+		if keep := math.Max(used[i]*bandwidthHeadroom, alloc[i]/bandwidthFloor); keep < alloc[i] {
+			spare += alloc[i] - keep
+			alloc[i] = keep
+			idle[i] = true
+		} else {
+			busy += s
+		}
+	}
+	if busy == 0 {
+		return alloc
+	}
+	for i, s := range shares {
+		if !idle[i] {
+			alloc[i] += spare * float64(s) / float64(busy)
+		}
+	}
+	return alloc
+}
+
+// connThrottle holds the devp2p limiters and traffic counters.
+type connThrottle struct {
+	egress, ingress uint64 // bytes transferred, accessed atomically
+
+	egressLimiter  *rate.Limiter
+	ingressLimiter *rate.Limiter
+}
+
+func (t *connThrottle) traffic() (uint64, uint64) {
+	return atomic.LoadUint64(&t.egress), atomic.LoadUint64(&t.ingress)
+}
+
+// limitedConn is a connection whose traffic is throttled by the devp2p
+// limiters of the bandwidth manager.
+type limitedConn struct {
+	net.Conn
+	throttle *connThrottle
+}
+
+func (c *limitedConn) Read(b []byte) (n int, err error) {
+	if len(b) > bandwidthBurst {
+		b = b[:bandwidthBurst]
+	}
+	n, err = c.Conn.Read(b)
+	if n > 0 {
+		atomic.AddUint64(&c.throttle.ingress, uint64(n))
+		c.throttle.ingressLimiter.WaitN(context.Background(), n)
+	}
+	return n, err
+}
+
+func (c *limitedConn) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > bandwidthBurst {
+			chunk = chunk[:bandwidthBurst]
+		}
+		c.throttle.egressLimiter.WaitN(context.Background(), len(chunk))
+		written, err := c.Conn.Write(chunk)
+		n += written
+		atomic.AddUint64(&c.throttle.egress, uint64(written))
+		if err != nil {
+			return n, err
+		}
+		b = b[written:]
+	}
+	return n, nil
+}
+
+// setupBandwidth creates the bandwidth manager of the node and registers
+// devp2p as its first user.
+func (srv *Server) setupBandwidth() {
+	if srv.MaxBandwidth <= 0 {
+		return
+	}
+	share := srv.BandwidthShare
+	if share <= 0 {
+		share = 1
+	}
+	srv.throttle = &connThrottle{
+		egressLimiter:  rate.NewLimiter(rate.Inf, bandwidthBurst),
+		ingressLimiter: rate.NewLimiter(rate.Inf, bandwidthBurst),
+	}
+	srv.bandwidth = NewBandwidthManager(srv.MaxBandwidth)
+	srv.bandwidth.Register(&BandwidthUser{
+		Name:    "p2p",
+		Share:   share,
+		Egress:  srv.throttle.egressLimiter,
+		Ingress: srv.throttle.ingressLimiter,
+		Traffic: srv.throttle.traffic,
+	})
+	srv.loopWG.Add(1)
+	go func() {
+		defer srv.loopWG.Done()
+		srv.bandwidth.loop(srv.quit)
+	}()
+}
+
+// Bandwidth returns the bandwidth manager of the node, nil if the bandwidth
+// isn't capped.
+func (srv *Server) Bandwidth() *BandwidthManager {
+	return srv.bandwidth
+}
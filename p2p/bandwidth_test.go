@@ -0,0 +1,91 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"math"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestAllocateBandwidth(t *testing.T) {
+	tests := []struct {
+		shares []int
+		used   []float64
+		want   []float64
+	}{
+		// Split by shares alone
+		{[]int{1, 3}, nil, []float64{250, 750}},
+		// Both users saturate their share
+		{[]int{1, 3}, []float64{250, 750}, []float64{250, 750}},
+		// An idle user keeps its floor, the other gets the rest
+		{[]int{1, 1}, []float64{0, 500}, []float64{62.5, 937.5}},
+		// A user below its share keeps some headroom
+		{[]int{1, 1}, []float64{100, 500}, []float64{125, 875}},
+		// Nobody busy, everyone keeps what it has
+		{[]int{1, 1}, []float64{0, 0}, []float64{62.5, 62.5}},
+	}
+	for i, tt := range tests {
+		got := allocateBandwidth(1000, tt.shares, tt.used)
+		for j := range got {
+			if math.Abs(got[j]-tt.want[j]) > 1e-9 {
+				t.Errorf("test %d: allocation mismatch: have %v, want %v", i, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestBandwidthManagerCeiling(t *testing.T) {
+	m := NewBandwidthManager(1000)
+
+	var traffic [2]uint64
+	capped := &BandwidthUser{
+		Name:    "capped",
+		Share:   1,
+		Egress:  rate.NewLimiter(100, 1),
+		Ingress: rate.NewLimiter(rate.Inf, 1),
+		Traffic: func() (uint64, uint64) { return traffic[0], traffic[1] },
+	}
+	free := &BandwidthUser{
+		Name:    "free",
+		Share:   1,
+		Egress:  rate.NewLimiter(rate.Inf, 1),
+		Ingress: rate.NewLimiter(rate.Inf, 1),
+		Traffic: func() (uint64, uint64) { return 0, 0 },
+	}
+	if err := m.Register(capped); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Register(free); err != nil {
+		t.Fatal(err)
+	}
+	if have := capped.Egress.Limit(); have != 100 {
+		t.Errorf("capped egress limit mismatch: have %v, want 100", have)
+	}
+	if have := capped.Ingress.Limit(); have != 500 {
+		t.Errorf("capped ingress limit mismatch: have %v, want 500", have)
+	}
+	m.Unregister("capped")
+	if have := capped.Ingress.Limit(); have != rate.Inf {
+		t.Errorf("unregistered ingress limit mismatch: have %v, want unlimited", have)
+	}
+	if err := m.Register(&BandwidthUser{Name: "broken"}); err != errInvalidBandwidthUser {
+		t.Errorf("invalid user error mismatch: have %v, want %v", err, errInvalidBandwidthUser)
+	}
+}
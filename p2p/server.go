@@ -156,6 +156,15 @@ type Config struct {
 	// Logger is a custom logger to use with the p2p.Server.
 	Logger log.Logger `toml:",omitempty"`
 
+	// MaxBandwidth caps the traffic of the node in bytes per second and
+	// direction. The cap is shared by devp2p and the services registering
+	// with the bandwidth manager. Zero leaves the bandwidth unlimited.
+	MaxBandwidth int `toml:",omitempty"`
+
+	// BandwidthShare is the weight of devp2p in the split of MaxBandwidth.
+	// Zero defaults to 1.
+	BandwidthShare int `toml:",omitempty"`
+
 	clock mclock.Clock
 }
 
@@ -196,6 +205,10 @@ type Server struct {
 	checkpointPostHandshake chan *conn
 	checkpointAddPeer       chan *conn
 
+	// Node bandwidth cap, nil if unlimited.
+	bandwidth *BandwidthManager
+	throttle  *connThrottle
+
 	// State of run loop and listenLoop.
 	inboundHistory expHeap
 }
@@ -483,6 +496,7 @@ func (srv *Server) Start() (err error) {
 		return err
 	}
 	srv.setupDialScheduler()
+	srv.setupBandwidth()
 
 	srv.loopWG.Add(1)
 	go srv.run()
@@ -914,6 +928,9 @@ func (srv *Server) checkInboundConn(fd net.Conn, remoteIP net.IP) error {
 // as a peer. It returns when the connection has been added as a peer
 // or the handshakes have failed.
 func (srv *Server) SetupConn(fd net.Conn, flags connFlag, dialDest *enode.Node) error {
+	if srv.bandwidth != nil {
+		fd = &limitedConn{Conn: fd, throttle: srv.throttle}
+	}
 	c := &conn{fd: fd, transport: srv.newTransport(fd), flags: flags, cont: make(chan error)}
 	err := srv.setupConn(c, flags, dialDest)
 	if err != nil {
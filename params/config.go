@@ -178,6 +178,8 @@ var (
 		PetersburgBlock:     big.NewInt(0),
 		IstanbulBlock:       nil,
 		EWASMBlock:          nil,
+		ONNXBlock:           nil,
+		MetaVersionBlock:    nil,
 		Cuckoo:              new(CuckooConfig),
 		Clique:              nil}
 
@@ -188,7 +190,7 @@ var (
 	// adding flags to the config to also have to set these fields.
 	// AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
 
-	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(CuckooConfig), nil}
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, nil, new(CuckooConfig), nil}
 	TestRules       = TestChainConfig.Rules(new(big.Int))
 )
 
@@ -217,6 +219,8 @@ type ChainConfig struct {
 	PetersburgBlock     *big.Int `json:"petersburgBlock,omitempty"`     // Petersburg switch block (nil = same as Constantinople)
 	IstanbulBlock       *big.Int `json:"istanbulBlock,omitempty"`       // Istanbul switch block (nil = no fork, 0 = already on istanbul)
 	EWASMBlock          *big.Int `json:"ewasmBlock,omitempty"`          // EWASM switch block (nil = no fork, 0 = already activated)
+	ONNXBlock           *big.Int `json:"onnxBlock,omitempty"`           // ONNX model switch block (nil = no fork, 0 = already activated)
+	MetaVersionBlock    *big.Int `json:"metaVersionBlock,omitempty"`    // Versioned upload meta switch block (nil = no fork, 0 = already activated)
 	// Various consensus engines
 	Cuckoo *CuckooConfig `json:"cuckoo,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
@@ -318,6 +322,18 @@ func (c *ChainConfig) IsEWASM(num *big.Int) bool {
 	return isForked(c.EWASMBlock, num)
 }
 
+// IsONNX returns whether num represents a block number after the ONNX fork,
+// from which models published in onnx format are inferred.
+func (c *ChainConfig) IsONNX(num *big.Int) bool {
+	return isForked(c.ONNXBlock, num)
+}
+
+// IsMetaVersion returns whether num represents a block number after the
+// MetaVersion fork, from which uploads may carry a versioned meta.
+func (c *ChainConfig) IsMetaVersion(num *big.Int) bool {
+	return isForked(c.MetaVersionBlock, num)
+}
+
 // GasTable returns the gas table corresponding to the current phase (homestead or homestead reprice).
 //
 // The returned GasTable's fields shouldn't, under any circumstances, be changed.
@@ -427,6 +443,12 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.EWASMBlock, newcfg.EWASMBlock, head) {
 		return newCompatError("ewasm fork block", c.EWASMBlock, newcfg.EWASMBlock)
 	}
+	if isForkIncompatible(c.ONNXBlock, newcfg.ONNXBlock, head) {
+		return newCompatError("onnx fork block", c.ONNXBlock, newcfg.ONNXBlock)
+	}
+	if isForkIncompatible(c.MetaVersionBlock, newcfg.MetaVersionBlock, head) {
+		return newCompatError("meta version fork block", c.MetaVersionBlock, newcfg.MetaVersionBlock)
+	}
 	return nil
 }
 
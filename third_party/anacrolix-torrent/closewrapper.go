@@ -0,0 +1,12 @@
+package torrent
+
+import "net"
+
+type closeWrapper struct {
+	net.Conn
+	closer func() error
+}
+
+func (me closeWrapper) Close() error {
+	return me.closer()
+}
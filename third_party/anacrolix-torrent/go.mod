@@ -0,0 +1,45 @@
+module github.com/anacrolix/torrent
+
+require (
+	bazil.org/fuse v0.0.0-20200407214033-5883e5a4b512
+	github.com/alexflint/go-arg v1.3.0
+	github.com/anacrolix/dht/v2 v2.6.1-0.20200416071723-3850fa1b802a
+	github.com/anacrolix/envpprof v1.1.0
+	github.com/anacrolix/go-libutp v1.0.2
+	github.com/anacrolix/log v0.7.1-0.20200604014615-c244de44fd2d
+	github.com/anacrolix/missinggo v1.2.1
+	github.com/anacrolix/missinggo/perf v1.0.0
+	github.com/anacrolix/missinggo/v2 v2.4.1-0.20200419051441-747d9d7544c6
+	github.com/anacrolix/multiless v0.0.0-20200413040533-acfd16f65d5d
+	github.com/anacrolix/sync v0.2.0
+	github.com/anacrolix/tagflag v1.1.1-0.20200411025953-9bb5209d56c2
+	github.com/anacrolix/upnp v0.1.2-0.20200416075019-5e9378ed1425
+	github.com/anacrolix/utp v0.0.0-20180219060659-9e0e1d1d0572
+	github.com/bradfitz/iter v0.0.0-20191230175014-e8f45d346db8
+	github.com/davecgh/go-spew v1.1.1
+	github.com/dustin/go-humanize v1.0.0
+	github.com/edsrzf/mmap-go v1.0.0
+	github.com/elliotchance/orderedmap v1.2.2
+	github.com/frankban/quicktest v1.9.0
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/google/btree v1.0.0
+	github.com/gorilla/websocket v1.4.2
+	github.com/jessevdk/go-flags v1.4.0
+	github.com/lucas-clemente/quic-go v0.15.6 // indirect
+	github.com/mattn/go-sqlite3 v2.0.3+incompatible
+	github.com/pion/datachannel v1.4.16
+	github.com/pion/ice v0.7.14 // indirect
+	github.com/pion/webrtc/v2 v2.2.7
+	github.com/pkg/errors v0.9.1
+	github.com/stretchr/testify v1.5.1
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/crypto v0.0.0-20200429183012-4b2356b1ed79 // indirect
+	golang.org/x/net v0.0.0-20200501053045-e0ff5e5a1de5 // indirect
+	golang.org/x/sys v0.0.0-20200501145240-bc7a7d42d5c3 // indirect
+	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1
+	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543
+)
+
+go 1.13
+
+exclude bazil.org/fuse v0.0.0-20200419173433-3ba628eaf417
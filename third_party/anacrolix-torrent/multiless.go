@@ -0,0 +1,7 @@
+package torrent
+
+import "github.com/anacrolix/missinggo"
+
+type (
+	multiLess = missinggo.MultiLess
+)
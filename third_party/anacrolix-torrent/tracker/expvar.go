@@ -0,0 +1,7 @@
+package tracker
+
+import (
+	"expvar"
+)
+
+var vars = expvar.NewMap("tracker")